@@ -0,0 +1,63 @@
+package fibre
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ACMEChallengePrefix is the path HTTP-01 challenges are requested under.
+const ACMEChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeToken matches the base64url tokens of HTTP-01 challenges.
+var acmeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// struct ACMEConfig answers ACME HTTP-01 challenges ahead of routing and
+// authentication, from files in Dir and then by proxying to Upstream, which
+// receives the original Host header.
+type ACMEConfig struct {
+	Dir      string
+	Upstream string
+}
+
+// ACMEMiddleware returns middleware answering requests under
+// ACMEChallengePrefix from cfg, passing everything else to the wrapped
+// handler.
+func (ws *WebService) ACMEMiddleware(cfg ACMEConfig) func(http.Handler) http.Handler {
+	var upstream http.Handler
+	if cfg.Upstream != "" {
+		upstream = ws.SetupProxy(ProxyConfig{Path: ACMEChallengePrefix, Host: cfg.Upstream, PreserveHost: true})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, ACMEChallengePrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token := strings.TrimPrefix(r.URL.Path, ACMEChallengePrefix)
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !acmeToken.MatchString(token) {
+				ws.NotFoundHandler(w, r)
+				return
+			}
+
+			if cfg.Dir != "" {
+				if location, err := containedPath(cfg.Dir, token); err == nil {
+					if body, err := os.ReadFile(location); err == nil {
+						ws.Metrics.Inc("acme_challenges")
+						w.Header().Set("Content-Type", "text/plain")
+						w.Write(body)
+						return
+					}
+				}
+			}
+			if upstream != nil {
+				ws.Metrics.Inc("acme_challenges")
+				upstream.ServeHTTP(w, r)
+				return
+			}
+			ws.NotFoundHandler(w, r)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACMEChallenges(t *testing.T) {
+	var host string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		io.WriteString(w, "upstream-token.key")
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "local-token"), []byte("local-token.key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := NewWebService("test", ":0")
+	ws.Apikey = "secretkey"
+	ws.Wrap(ws.APIKeyMiddleware)
+	ws.ACME = &ACMEConfig{Dir: dir, Upstream: backend.URL}
+	handler := ws.Handler()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{ACMEChallengePrefix + "local-token", http.StatusOK, "local-token.key"},
+		{ACMEChallengePrefix + "upstream-token", http.StatusOK, "upstream-token.key"},
+		{ACMEChallengePrefix + "..%2fsecret", http.StatusNotFound, "404 page not found"},
+		{"/healthcheck", http.StatusUnauthorized, "\"Invalid api_key\"\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+tt.path, nil))
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.path, status, tt.status)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%v returned unexpected body: got %v want %v", tt.path, w.Body.String(), tt.body)
+		}
+	}
+
+	if host != "example.com" {
+		t.Errorf("ACME upstream received wrong Host: got %v want %v", host, "example.com")
+	}
+}
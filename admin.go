@@ -0,0 +1,36 @@
+package fibre
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminMiddleware restricts handlers to requests carrying ws.AdminKey in the
+// admin_key header.  Without an AdminKey every request is refused.
+func (ws *WebService) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ws.isAdmin(r) {
+			ws.JsonStatusResponse(w, "Invalid admin_key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether r carries ws.AdminKey in the admin_key header.
+func (ws *WebService) isAdmin(r *http.Request) bool {
+	key := r.Header.Get("admin_key")
+	return ws.AdminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(ws.AdminKey)) == 1
+}
+
+// Admin returns the /admin subrouter, protected by AdminMiddleware, creating
+// it on first use.
+func (ws *WebService) Admin() *mux.Router {
+	if ws.admin == nil {
+		ws.admin = ws.Router.PathPrefix("/admin").Subrouter()
+		ws.admin.Use(ws.AdminMiddleware)
+	}
+	return ws.admin
+}
@@ -0,0 +1,324 @@
+package fibre
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type contextKey int
+
+const (
+	apiKeyContextKey contextKey = iota
+	varsContextKey
+	gzipContextKey
+	loadContextKey
+)
+
+// struct Tier sets the limits for API keys assigned to it.  Zero values are
+// unlimited.
+type Tier struct {
+	Name              string
+	RequestsPerMinute int
+	Burst             int
+	Concurrency       int
+	DailyQuota        int64
+	MonthlyQuota      int64
+}
+
+// struct APIKey is a key issued to a client, Name identifying its holder.
+type APIKey struct {
+	Key  string
+	Name string
+	Tier string
+}
+
+// struct KeyUsage reports the requests made with a key.  Key is masked.
+type KeyUsage struct {
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+	Tier      string `json:"tier"`
+	Requests  int64  `json:"requests"`
+	Throttled int64  `json:"throttled"`
+	InFlight  int    `json:"in_flight"`
+}
+
+// keyState is a key's usage.  id, unlike the key, is kept by Rotate, so
+// rate limits and in flight requests carry over to the new key.
+type keyState struct {
+	APIKey
+	id        string
+	requests  int64
+	throttled int64
+	inflight  int
+}
+
+// struct KeyStore holds API keys and their tiers, enforcing each tier's rate
+// and concurrency limits, and quotas when Quotas is set.
+type KeyStore struct {
+	Quotas *Quotas
+
+	mu       sync.Mutex
+	keys     map[string]*keyState
+	tiers    map[string]Tier
+	limiters map[string]*RateLimiter
+	ids      uint64
+}
+
+// NewKeyStore returns an empty key store.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		keys:     make(map[string]*keyState),
+		tiers:    make(map[string]Tier),
+		limiters: make(map[string]*RateLimiter),
+	}
+}
+
+// SetTier adds or replaces a tier.
+func (ks *KeyStore) SetTier(t Tier) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.tiers[t.Name] = t
+	if rl, ok := ks.limiters[t.Name]; ok {
+		rl.SetLimits(t.RequestsPerMinute, t.Burst)
+	} else {
+		ks.limiters[t.Name] = NewRateLimiter(t.RequestsPerMinute, t.Burst)
+	}
+}
+
+// Tier returns the named tier.
+func (ks *KeyStore) Tier(name string) (Tier, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	t, ok := ks.tiers[name]
+	return t, ok
+}
+
+// Tiers returns the configured tiers ordered by name.
+func (ks *KeyStore) Tiers() []Tier {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	tiers := make([]Tier, 0, len(ks.tiers))
+	for _, t := range ks.tiers {
+		tiers = append(tiers, t)
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Name < tiers[j].Name })
+	return tiers
+}
+
+// AddKey adds k to the store.  Its tier must exist, or be empty for an
+// unlimited key.
+func (ks *KeyStore) AddKey(k APIKey) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if k.Key == "" {
+		return errors.New("fibre: empty api key")
+	}
+	if _, ok := ks.tiers[k.Tier]; !ok && k.Tier != "" {
+		return errors.New("fibre: unknown tier " + k.Tier)
+	}
+	if s, ok := ks.keys[k.Key]; ok {
+		s.APIKey = k
+		return nil
+	}
+	ks.ids++
+	ks.keys[k.Key] = &keyState{APIKey: k, id: strconv.FormatUint(ks.ids, 10)}
+	return nil
+}
+
+// RemoveKey revokes key.
+func (ks *KeyStore) RemoveKey(key string) {
+	ks.mu.Lock()
+	delete(ks.keys, key)
+	ks.mu.Unlock()
+}
+
+// GenerateKey returns a new random API key.
+func GenerateKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Rotate replaces key with a newly generated one, keeping its holder, tier,
+// usage counters and quota, and returns the new APIKey.
+func (ks *KeyStore) Rotate(key string) (APIKey, error) {
+	fresh, err := GenerateKey()
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	ks.mu.Lock()
+	s, ok := ks.keys[key]
+	if !ok {
+		ks.mu.Unlock()
+		return APIKey{}, errors.New("fibre: unknown api key")
+	}
+	delete(ks.keys, key)
+	s.Key = fresh
+	ks.keys[fresh] = s
+	k := s.APIKey
+	ks.mu.Unlock()
+
+	if ks.Quotas != nil {
+		ks.Quotas.Rename(key, fresh)
+	}
+	return k, nil
+}
+
+// Lookup returns the APIKey for key.
+func (ks *KeyStore) Lookup(key string) (APIKey, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	s, ok := ks.keys[key]
+	if !ok {
+		return APIKey{}, false
+	}
+	return s.APIKey, true
+}
+
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
+
+// Usage returns the usage counters for every key ordered by name.
+func (ks *KeyStore) Usage() []KeyUsage {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	usage := make([]KeyUsage, 0, len(ks.keys))
+	for _, s := range ks.keys {
+		usage = append(usage, KeyUsage{
+			Name:      s.Name,
+			Key:       maskKey(s.Key),
+			Tier:      s.Tier,
+			Requests:  s.requests,
+			Throttled: s.throttled,
+			InFlight:  s.inflight,
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage
+}
+
+// acquire admits a request for key against its tier, returning the key's
+// state, the tier, the tokens remaining and, when refused, how long to
+// wait.  Admitted requests must be released with the state returned, which
+// survives the key being rotated.
+func (ks *KeyStore) acquire(key string) (*keyState, Tier, bool, int, time.Duration) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	s, ok := ks.keys[key]
+	if !ok {
+		return nil, Tier{}, false, 0, 0
+	}
+	t := ks.tiers[s.Tier]
+	if t.Concurrency > 0 && s.inflight >= t.Concurrency {
+		s.throttled++
+		return s, t, false, 0, time.Second
+	}
+	remaining, retry := 0, time.Duration(0)
+	if rl, ok := ks.limiters[s.Tier]; ok {
+		var allowed bool
+		if allowed, remaining, retry = rl.Allow(s.id); !allowed {
+			s.throttled++
+			return s, t, false, 0, retry
+		}
+	}
+	s.requests++
+	s.inflight++
+	return s, t, true, remaining, 0
+}
+
+// refund undoes acquire's accounting for a request refused after it was
+// admitted, returning its rate limit token and the tokens remaining.  The
+// request must still be released.
+func (ks *KeyStore) refund(s *keyState) int {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if s.requests > 0 {
+		s.requests--
+	}
+	if rl, ok := ks.limiters[s.Tier]; ok {
+		return rl.refund(s.id)
+	}
+	return 0
+}
+
+func (ks *KeyStore) release(s *keyState) {
+	ks.mu.Lock()
+	if s.inflight > 0 {
+		s.inflight--
+	}
+	ks.mu.Unlock()
+}
+
+// KeyFromContext returns the APIKey a request was authenticated with by
+// APIKeyMiddleware.
+func KeyFromContext(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(apiKeyContextKey).(APIKey)
+	return k, ok
+}
+
+// serveWithKey authenticates apik against ws.Keys and enforces its tier
+// before calling next.
+func (ws *WebService) serveWithKey(w http.ResponseWriter, r *http.Request, apik string, next http.Handler) {
+	key, ok := ws.Keys.Lookup(apik)
+	if len(apik) == 0 || !ok {
+		ws.JsonStatusResponse(w, "Invalid api_key", http.StatusUnauthorized)
+		return
+	}
+
+	state, tier, allowed, remaining, retry := ws.Keys.acquire(apik)
+	if tier.RequestsPerMinute > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(tier.RequestsPerMinute))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	}
+	if !allowed {
+		ws.Metrics.Inc("api_key_throttled")
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)+1))
+		ws.JsonStatusResponse(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	defer ws.Keys.release(state)
+
+	if ws.Keys.Quotas != nil {
+		st, ok := ws.Keys.Quotas.Use(apik, tier)
+		if tier.DailyQuota > 0 || tier.MonthlyQuota > 0 {
+			setQuotaHeaders(w, st)
+		}
+		if !ok {
+			// a refused request spends neither a token nor a count.
+			remaining := ws.Keys.refund(state)
+			if tier.RequestsPerMinute > 0 {
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			}
+			ws.Metrics.Inc("api_key_over_quota")
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(st.Reset)/time.Second)+1))
+			ws.JsonStatusResponse(w, "Quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+}
+
+// KeyUsageHandler reports per key usage counters as JSON, for the admin
+// router.
+func (ws *WebService) KeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage := []KeyUsage{}
+	if ws.Keys != nil {
+		usage = ws.Keys.Usage()
+	}
+	ws.writeJSON(w, usage, http.StatusOK)
+}
@@ -0,0 +1,131 @@
+package fibre
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIKeyMiddlewareTiers(t *testing.T) {
+	ws := new(WebService)
+	ws.Keys = NewKeyStore()
+	ws.Keys.SetTier(Tier{Name: "free", RequestsPerMinute: 60, Burst: 2})
+	if err := ws.Keys.AddKey(APIKey{Key: "k1", Name: "alice", Tier: "free"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var holder string
+	handler := ws.APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, _ := KeyFromContext(r.Context())
+		holder = k.Name
+	}))
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("api_key", "k1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("APIKeyMiddleware returned unexpected status codes: got %v want [200 200 429]", codes)
+	}
+
+	if holder != "alice" {
+		t.Errorf("APIKeyMiddleware did not set the key in the context: got %v want %v", holder, "alice")
+	}
+
+	usage := ws.Keys.Usage()
+	if len(usage) != 1 || usage[0].Requests != 2 || usage[0].Throttled != 1 {
+		t.Errorf("KeyStore returned unexpected usage: %+v", usage)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("api_key", "unknown")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("APIKeyMiddleware returned wrong status code for an unknown key: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestKeyStoreConcurrency(t *testing.T) {
+	ks := NewKeyStore()
+	ks.SetTier(Tier{Name: "one", Concurrency: 1})
+	ks.AddKey(APIKey{Key: "k", Tier: "one"})
+
+	s, _, ok, _, _ := ks.acquire("k")
+	if !ok {
+		t.Fatalf("KeyStore refused the first concurrent request")
+	}
+	if _, _, ok, _, _ := ks.acquire("k"); ok {
+		t.Errorf("KeyStore admitted a request over the concurrency limit")
+	}
+	ks.release(s)
+	if _, _, ok, _, _ := ks.acquire("k"); !ok {
+		t.Errorf("KeyStore refused a request after release")
+	}
+}
+
+func TestKeyStoreRotateInFlight(t *testing.T) {
+	ks := NewKeyStore()
+	ks.SetTier(Tier{Name: "one", Concurrency: 1, RequestsPerMinute: 1, Burst: 2})
+	ks.AddKey(APIKey{Key: "k", Name: "alice", Tier: "one"})
+
+	s, _, ok, _, _ := ks.acquire("k")
+	if !ok {
+		t.Fatalf("KeyStore refused the first request")
+	}
+	rotated, err := ks.Rotate("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok, _, _ := ks.acquire(rotated.Key); ok {
+		t.Errorf("KeyStore admitted a request over the concurrency limit after rotation")
+	}
+	ks.release(s)
+
+	if _, _, ok, _, _ := ks.acquire(rotated.Key); !ok {
+		t.Errorf("KeyStore refused a request after an in flight request was released across rotation")
+	}
+	if usage := ks.Usage(); usage[0].InFlight != 1 {
+		t.Errorf("KeyStore reported wrong in flight requests: got %v want %v", usage[0].InFlight, 1)
+	}
+	ks.release(s)
+	if _, _, ok, _, _ := ks.acquire(rotated.Key); ok {
+		t.Errorf("KeyStore reset the rate limit on rotation")
+	}
+}
+
+func TestKeyUsageHandlerAdmin(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	ws.AdminKey = "admin"
+	ws.Keys = NewKeyStore()
+	ws.Keys.AddKey(APIKey{Key: "secretkey", Name: "bob"})
+	ws.Admin().HandleFunc("/keys", ws.KeyUsageHandler)
+
+	w := httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/keys", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("KeyUsageHandler returned wrong status code without admin_key: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+
+	var usage []KeyUsage
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Key != "secr****" {
+		t.Errorf("KeyUsageHandler returned unexpected usage: %+v", usage)
+	}
+}
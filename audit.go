@@ -0,0 +1,100 @@
+package fibre
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultAuditRecent is the number of events an AuditLog keeps in memory.
+const DefaultAuditRecent = 1000
+
+// struct AuditEvent records a security relevant action: who (Actor, from
+// Remote) did what (Action) to what (Target), and whether it succeeded.
+type AuditEvent struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor,omitempty"`
+	Remote  string            `json:"remote,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Success bool              `json:"success"`
+	Detail  map[string]string `json:"detail,omitempty"`
+}
+
+// struct AuditLog writes events as JSON lines to its writer, and keeps the
+// most recent for AuditHandler.  A nil *AuditLog logs events with the log
+// package instead.
+type AuditLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	enc    *json.Encoder
+	recent []AuditEvent
+	next   int
+}
+
+// NewAuditLog returns an audit log writing to w, or only keeping events in
+// memory when w is nil.
+func NewAuditLog(w io.Writer) *AuditLog {
+	al := &AuditLog{w: w}
+	if w != nil {
+		al.enc = json.NewEncoder(w)
+	}
+	return al
+}
+
+// Record adds e, timestamping it when Time is zero.
+func (al *AuditLog) Record(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if al == nil {
+		log.Printf("audit: %v actor=%q remote=%v target=%q success=%v %v", e.Action, e.Actor, e.Remote, e.Target, e.Success, e.Detail)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.enc != nil {
+		if err := al.enc.Encode(e); err != nil {
+			log.Printf("audit: %v", err)
+		}
+	}
+	if len(al.recent) < DefaultAuditRecent {
+		al.recent = append(al.recent, e)
+	} else {
+		al.recent[al.next] = e
+	}
+	al.next = (al.next + 1) % DefaultAuditRecent
+}
+
+// Recent returns the events kept in memory, oldest first.
+func (al *AuditLog) Recent() []AuditEvent {
+	if al == nil {
+		return []AuditEvent{}
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	events := make([]AuditEvent, 0, len(al.recent))
+	if len(al.recent) == DefaultAuditRecent {
+		events = append(events, al.recent[al.next:]...)
+		return append(events, al.recent[:al.next]...)
+	}
+	return append(events, al.recent...)
+}
+
+// audit records an event for r in ws.Audit.
+func (ws *WebService) audit(r *http.Request, e AuditEvent) {
+	if r != nil {
+		e.Remote = clientIP(r)
+	}
+	ws.Audit.Record(e)
+}
+
+// AuditHandler writes the recent audit events as JSON, for the admin
+// router.
+func (ws *WebService) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	ws.writeJSON(w, ws.Audit.Recent(), http.StatusOK)
+}
@@ -0,0 +1,36 @@
+package fibre
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	al := NewAuditLog(&buf)
+	for i := 0; i < DefaultAuditRecent+2; i++ {
+		al.Record(AuditEvent{Action: "test", Target: strconv.Itoa(i)})
+	}
+
+	recent := al.Recent()
+	if len(recent) != DefaultAuditRecent || recent[0].Target != "2" || recent[len(recent)-1].Target != strconv.Itoa(DefaultAuditRecent+1) {
+		t.Errorf("Recent returned wrong events: got %d from %v", len(recent), recent[0].Target)
+	}
+
+	var first AuditEvent
+	if err := json.NewDecoder(&buf).Decode(&first); err != nil || first.Target != "0" || first.Time.IsZero() {
+		t.Errorf("audit log wrote wrong event: got %+v (%v)", first, err)
+	}
+
+	ws := NewWebService("test", ":0")
+	ws.Audit = al
+	w := httptest.NewRecorder()
+	ws.AuditHandler(w, httptest.NewRequest("GET", "/admin/audit", nil))
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("AuditHandler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,236 @@
+// Package bench benchmarks the fibre request path: router dispatch, the
+// middleware chain, JSON helpers and proxy overhead.  Results can be saved
+// as a baseline and later runs compared against it, to gate performance
+// affecting changes.
+//
+// From a test file:
+//
+//	func BenchmarkFibre(b *testing.B) {
+//		bench.Run(b)
+//	}
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+
+	fibre "github.com/lakesite/ls-fibre"
+)
+
+// struct Case is a named benchmark.
+type Case struct {
+	Name  string
+	Bench func(b *testing.B)
+}
+
+// struct Result records one benchmark run.
+type Result struct {
+	Name        string  `json:"name"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+}
+
+// struct Regression is a benchmark which got slower or allocates more than
+// its baseline.
+type Regression struct {
+	Baseline Result `json:"baseline"`
+	Current  Result `json:"current"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %.0f ns/op (baseline %.0f), %d allocs/op (baseline %d)",
+		r.Current.Name, r.Current.NsPerOp, r.Baseline.NsPerOp, r.Current.AllocsPerOp, r.Baseline.AllocsPerOp)
+}
+
+// discardWriter is a ResponseWriter which allocates nothing itself, so the
+// benchmarks measure fibre alone.
+type discardWriter struct {
+	header http.Header
+}
+
+func (dw *discardWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (dw *discardWriter) WriteHeader(status int) {}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func serve(b *testing.B, handler http.Handler, r *http.Request) {
+	w := newDiscardWriter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// RouterDispatch measures matching the last of 50 routes on ws.Router.
+func RouterDispatch(b *testing.B) {
+	ws := fibre.NewWebService("bench", ":0")
+	for i := 0; i < 50; i++ {
+		ws.Router.HandleFunc("/api/v1/resource"+strconv.Itoa(i)+"/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+	}
+	serve(b, ws.Router, httptest.NewRequest(http.MethodGet, "/api/v1/resource49/42", nil))
+}
+
+// BackendDispatch measures the same routes served by a ServeMux backend.
+func BackendDispatch(b *testing.B) {
+	ws := fibre.NewWebServiceWithRouter("bench", ":0", fibre.NewServeMuxBackend())
+	for i := 0; i < 50; i++ {
+		ws.Handle(http.MethodGet, "/api/v1/resource"+strconv.Itoa(i)+"/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	serve(b, ws.Handler(), httptest.NewRequest(http.MethodGet, "/api/v1/resource49/42", nil))
+}
+
+// MiddlewareChain measures ws.Handler() with a method policy, the
+// public-site posture and a health check behind them.
+func MiddlewareChain(b *testing.B) {
+	ws := fibre.NewWebService("bench", ":0")
+	ws.MethodPolicy = &fibre.MethodPolicy{Deny: []string{"TRACE"}}
+	p, err := fibre.PostureProfile("public-site")
+	if err != nil {
+		b.Fatal(err)
+	}
+	p.RequestsPerMinute = 0
+	ws.ApplyPosture(p)
+	serve(b, ws.Handler(), httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+}
+
+// JSONResponse measures JsonStatusResponse.
+func JSONResponse(b *testing.B) {
+	ws := fibre.NewWebService("bench", ":0")
+	w := newDiscardWriter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ws.JsonStatusResponse(w, "ok", http.StatusOK)
+	}
+}
+
+// ProxyOverhead measures a request through SetupProxy to a local backend.
+// Compare with Direct, the same request made to the backend itself.
+func ProxyOverhead(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	ws := fibre.NewWebService("bench", ":0")
+	proxy := ws.SetupProxy(fibre.ProxyConfig{Path: "/", Host: backend.URL})
+	serve(b, proxy, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// Direct measures a request made straight to a local backend, the baseline
+// for ProxyOverhead.
+func Direct(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	client := backend.Client()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// Cases returns the suite.
+func Cases() []Case {
+	return []Case{
+		{"RouterDispatch", RouterDispatch},
+		{"BackendDispatch", BackendDispatch},
+		{"MiddlewareChain", MiddlewareChain},
+		{"JSONResponse", JSONResponse},
+		{"ProxyOverhead", ProxyOverhead},
+		{"Direct", Direct},
+	}
+}
+
+// Run runs the suite as sub-benchmarks of b.
+func Run(b *testing.B) {
+	for _, c := range Cases() {
+		b.Run(c.Name, c.Bench)
+	}
+}
+
+// RunAll runs the suite outside of go test, returning the results.
+func RunAll() []Result {
+	var results []Result
+	for _, c := range Cases() {
+		r := testing.Benchmark(c.Bench)
+		results = append(results, Result{
+			Name:        c.Name,
+			NsPerOp:     float64(r.T.Nanoseconds()) / float64(r.N),
+			AllocsPerOp: r.AllocsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+		})
+	}
+	return results
+}
+
+// SaveBaseline writes results to path as JSON.
+func SaveBaseline(path string, results []Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadBaseline reads results written by SaveBaseline.
+func LoadBaseline(path string) ([]Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Compare returns the benchmarks in current which allocate more per op
+// than in baseline, or are slower by more than tolerance (0.1 for 10%).
+// Benchmarks missing from baseline are ignored.
+func Compare(baseline, current []Result, tolerance float64) []Regression {
+	base := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		base[r.Name] = r
+	}
+
+	var regressions []Regression
+	for _, r := range current {
+		b, ok := base[r.Name]
+		if !ok {
+			continue
+		}
+		if r.AllocsPerOp > b.AllocsPerOp || r.NsPerOp > b.NsPerOp*(1+tolerance) {
+			regressions = append(regressions, Regression{Baseline: b, Current: r})
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool { return regressions[i].Current.Name < regressions[j].Current.Name })
+	return regressions
+}
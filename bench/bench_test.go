@@ -0,0 +1,71 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func BenchmarkFibre(b *testing.B) {
+	Run(b)
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []Result{
+		{Name: "a", NsPerOp: 100, AllocsPerOp: 2},
+		{Name: "b", NsPerOp: 100, AllocsPerOp: 2},
+		{Name: "c", NsPerOp: 100, AllocsPerOp: 2},
+	}
+	current := []Result{
+		{Name: "a", NsPerOp: 105, AllocsPerOp: 2},
+		{Name: "b", NsPerOp: 150, AllocsPerOp: 2},
+		{Name: "c", NsPerOp: 90, AllocsPerOp: 3},
+		{Name: "d", NsPerOp: 1000, AllocsPerOp: 30},
+	}
+
+	regressions := Compare(baseline, current, 0.1)
+	if len(regressions) != 2 || regressions[0].Current.Name != "b" || regressions[1].Current.Name != "c" {
+		t.Errorf("Compare returned unexpected regressions: %v", regressions)
+	}
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	results := []Result{{Name: "a", NsPerOp: 1.5, AllocsPerOp: 1, BytesPerOp: 16}}
+	if err := SaveBaseline(path, results); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0] != results[0] {
+		t.Errorf("LoadBaseline returned unexpected results: got %v want %v", loaded, results)
+	}
+}
+
+// TestRegressionGate compares the suite with the baseline named by
+// FIBRE_BENCH_BASELINE, writing it instead when FIBRE_BENCH_UPDATE is set.
+func TestRegressionGate(t *testing.T) {
+	path := os.Getenv("FIBRE_BENCH_BASELINE")
+	if path == "" {
+		t.Skip("FIBRE_BENCH_BASELINE not set")
+	}
+
+	results := RunAll()
+	if os.Getenv("FIBRE_BENCH_UPDATE") != "" {
+		if err := SaveBaseline(path, results); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	baseline, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range Compare(baseline, results, 0.2) {
+		t.Errorf("performance regression: %v", r)
+	}
+}
@@ -0,0 +1,84 @@
+package fibre
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// struct CORSConfig lists the cross origin requests browsers may make.  An
+// Origins entry of "*" allows any origin; Methods defaults to GET and HEAD.
+type CORSConfig struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+func (c *CORSConfig) allowsOrigin(origin string) (allowed, any bool) {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(o, origin) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// CORSMiddleware returns middleware which answers preflight requests and
+// adds CORS headers for origins allowed by cfg.  Requests from other
+// origins get no CORS headers, so browsers refuse them.
+func (ws *WebService) CORSMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			allowed, any := cfg.allowsOrigin(origin)
+			if !allowed {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if any && !cfg.Credentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.Credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				if len(cfg.Headers) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.Headers, ", "))
+				}
+				if cfg.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge/time.Second)))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Package dav mounts WebDAV file shares (golang.org/x/net/webdav) on fibre
+// instances, for simple shares for internal teams.
+package dav
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	fibre "github.com/lakesite/ls-fibre"
+	"golang.org/x/net/webdav"
+)
+
+// readMethods are allowed on read only shares.
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// struct Config describes a share.  Auth defaults to ws.APIKeyMiddleware.
+type Config struct {
+	Root     string
+	ReadOnly bool
+	Auth     func(http.Handler) http.Handler
+}
+
+// Handler returns the WebDAV handler for cfg, serving under prefix.
+func Handler(ws *fibre.WebService, prefix string, cfg Config) http.Handler {
+	dav := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(prefix, "/"),
+		FileSystem: webdav.Dir(cfg.Root),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("%v webdav %v %v: %v", ws.Instance, r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	var h http.Handler = dav
+	if cfg.ReadOnly {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !readMethods[r.Method] {
+				ws.JsonStatusResponse(w, "Share is read only", http.StatusForbidden)
+				return
+			}
+			dav.ServeHTTP(w, r)
+		})
+	}
+
+	auth := cfg.Auth
+	if auth == nil {
+		auth = ws.APIKeyMiddleware
+	}
+	return auth(ws.NoTimeoutMiddleware(h))
+}
+
+// Mount registers a share of cfg.Root at prefix on ws.Router, exempt from
+// the server timeouts so large transfers are not cut off.  WebDAV methods
+// (PROPFIND, MKCOL, ...) must be allowed by ws.MethodPolicy, if one is set.
+func Mount(ws *fibre.WebService, prefix string, cfg Config) *mux.Route {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return ws.Router.PathPrefix(prefix + "/").Handler(Handler(ws, prefix, cfg))
+}
@@ -0,0 +1,65 @@
+package dav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fibre "github.com/lakesite/ls-fibre"
+)
+
+func TestMount(t *testing.T) {
+	root := t.TempDir()
+	ws := fibre.NewWebService("test", ":0")
+	ws.Apikey = "secretkey"
+	Mount(ws, "/share/", Config{Root: root})
+	handler := ws.Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+	}{
+		{"PUT", "/share/notes.txt", "", "hello", http.StatusUnauthorized},
+		{"PUT", "/share/notes.txt", "secretkey", "hello", http.StatusCreated},
+		{"MKCOL", "/share/docs", "secretkey", "", http.StatusCreated},
+		{"PROPFIND", "/share/", "secretkey", "", http.StatusMultiStatus},
+		{"GET", "/share/notes.txt", "secretkey", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("api_key", tt.key)
+		req.Header.Set("Depth", "1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v", tt.method, tt.path, status, tt.status)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Mount stored unexpected file: got %q, %v want %q", data, err, "hello")
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	ws := fibre.NewWebService("test", ":0")
+	Mount(ws, "/share", Config{
+		Root:     t.TempDir(),
+		ReadOnly: true,
+		Auth:     func(next http.Handler) http.Handler { return next },
+	})
+
+	w := httptest.NewRecorder()
+	ws.Handler().ServeHTTP(w, httptest.NewRequest("PUT", "/share/x.txt", strings.NewReader("x")))
+	if status := w.Code; status != http.StatusForbidden {
+		t.Errorf("read only share returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+}
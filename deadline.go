@@ -0,0 +1,117 @@
+package fibre
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTimeout is used for the server read and write timeouts when the
+// WebService does not set its own.
+const DefaultTimeout = 15 * time.Second
+
+func timeoutOrDefault(d time.Duration) time.Duration {
+	switch {
+	case d < 0:
+		return 0
+	case d == 0:
+		return DefaultTimeout
+	}
+	return d
+}
+
+// ExtendWriteDeadline pushes the write deadline for w out to d from now.  A
+// zero or negative d clears the deadline.
+func ExtendWriteDeadline(w http.ResponseWriter, d time.Duration) error {
+	rc := http.NewResponseController(w)
+	if d <= 0 {
+		return rc.SetWriteDeadline(time.Time{})
+	}
+	return rc.SetWriteDeadline(time.Now().Add(d))
+}
+
+// struct StreamWriter wraps a ResponseWriter for long running responses
+// (SSE, downloads).  Each Write must complete within Timeout, so a client
+// that stops reading is cut off while a healthy stream may run indefinitely.
+type StreamWriter struct {
+	http.ResponseWriter
+	Timeout time.Duration
+}
+
+// NewStreamWriter wraps w with a per-write deadline of timeout.
+func NewStreamWriter(w http.ResponseWriter, timeout time.Duration) *StreamWriter {
+	return &StreamWriter{ResponseWriter: w, Timeout: timeout}
+}
+
+// Write extends the write deadline before writing p.
+func (sw *StreamWriter) Write(p []byte) (int, error) {
+	if err := ExtendWriteDeadline(sw.ResponseWriter, sw.Timeout); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush sends any buffered data to the client.
+func (sw *StreamWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (sw *StreamWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// StreamMiddleware returns middleware which replaces the global write
+// timeout with a per-write timeout, for streaming routes.
+func (ws *WebService) StreamMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(NewStreamWriter(w, timeout), r)
+		})
+	}
+}
+
+// struct Deadlines overrides the server read and write timeouts for a route.
+// A zero duration removes the deadline entirely.
+type Deadlines struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// DeadlineMiddleware returns middleware which replaces the server timeouts
+// with d for the wrapped handler.
+func (ws *WebService) DeadlineMiddleware(d Deadlines) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline(d.Read))
+			rc.SetWriteDeadline(deadline(d.Write))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NoTimeoutMiddleware exempts the wrapped handler from the server timeouts,
+// for SSE, WebSocket and long download routes.
+func (ws *WebService) NoTimeoutMiddleware(next http.Handler) http.Handler {
+	return ws.DeadlineMiddleware(Deadlines{})(next)
+}
+
+// HandleStream registers f on path, exempt from the server timeouts, and
+// counted as an active stream while it runs.
+func (ws *WebService) HandleStream(path string, f func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return ws.Router.Handle(path, ws.NoTimeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.streams.Add(1)
+		defer ws.streams.Add(-1)
+		f(w, r)
+	})))
+}
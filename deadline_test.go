@@ -0,0 +1,82 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStreamWriterRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	sw := NewStreamWriter(w, time.Second)
+
+	if _, err := io.WriteString(sw, "data: ok\n\n"); err != nil {
+		t.Errorf("StreamWriter returned unexpected error: %v", err)
+	}
+
+	if w.Body.String() != "data: ok\n\n" {
+		t.Errorf("StreamWriter returned unexpected body: got %v want %v", w.Body.String(), "data: ok\n\n")
+	}
+}
+
+func TestStreamMiddlewareOutlivesWriteTimeout(t *testing.T) {
+	ws := new(WebService)
+
+	handler := ws.StreamMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			io.WriteString(w, "tick\n")
+			http.NewResponseController(w).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("StreamMiddleware stream was cut off: %v", err)
+	}
+
+	if len(body) != 25 {
+		t.Errorf("StreamMiddleware returned unexpected body length: got %v want %v", len(body), 25)
+	}
+}
+
+func TestHandleStreamExemptFromTimeouts(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+
+	ws.HandleStream("/events", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	server := httptest.NewUnstartedServer(ws.Router)
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("HandleStream route hit the server write timeout: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("HandleStream returned unexpected body: got %v want %v", string(body), "done")
+	}
+}
@@ -0,0 +1,139 @@
+package fibre
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultDecompressMaxBytes bounds decompressed request bodies.
+	DefaultDecompressMaxBytes = 10 << 20
+
+	// DefaultDecompressMaxRatio bounds decompressed to compressed size.
+	DefaultDecompressMaxRatio = 100
+
+	// ratioSlack is decompressed before the ratio is enforced, so small
+	// bodies which compress well are not refused.
+	ratioSlack = 64 << 10
+)
+
+// errCompressionRatio is returned reading request bodies which expand past
+// DecompressConfig.MaxRatio.
+var errCompressionRatio = errors.New("fibre: request body compression ratio too high")
+
+// struct DecompressConfig bounds decompressed request bodies, to refuse zip
+// bombs.  Zero values take the defaults.
+type DecompressConfig struct {
+	MaxBytes int64
+	MaxRatio int64
+}
+
+func (cfg DecompressConfig) withDefaults() DecompressConfig {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultDecompressMaxBytes
+	}
+	if cfg.MaxRatio <= 0 {
+		cfg.MaxRatio = DefaultDecompressMaxRatio
+	}
+	return cfg
+}
+
+// countingReader counts the compressed bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompressedBody enforces the limits while the body is read.
+type decompressedBody struct {
+	io.Reader
+	body       io.Closer
+	compressed *countingReader
+	cfg        DecompressConfig
+	n          int64
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.n += int64(n)
+	if b.n > b.cfg.MaxBytes {
+		return 0, &http.MaxBytesError{Limit: b.cfg.MaxBytes}
+	}
+	if b.n > ratioSlack && b.n > b.compressed.n*b.cfg.MaxRatio {
+		return 0, errCompressionRatio
+	}
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
+
+// newDecompressor returns a reader decoding encoding from r.  deflate is
+// zlib wrapped per RFC 9110, but raw deflate streams are accepted too.
+func newDecompressor(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, nil
+}
+
+// DecompressMiddleware returns middleware decoding gzip and deflate request
+// bodies before the wrapped handler sees them.  Other encodings are refused
+// with 415, and bodies which are malformed with 400.  Bodies over the limits
+// fail as they are read, with an *http.MaxBytesError once over MaxBytes.
+func (ws *WebService) DecompressMiddleware(cfg DecompressConfig) func(http.Handler) http.Handler {
+	cfg = cfg.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			compressed := &countingReader{r: r.Body}
+			reader, err := newDecompressor(encoding, compressed)
+			if reader == nil && err == nil {
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				ws.JsonStatusResponse(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				ws.Metrics.Inc("decompress_errors")
+				ws.JsonStatusResponse(w, "Malformed "+encoding+" request body", http.StatusBadRequest)
+				return
+			}
+
+			ws.Metrics.Inc("decompressed_requests")
+			r.Body = &decompressedBody{Reader: reader, body: r.Body, compressed: compressed, cfg: cfg}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			next.ServeHTTP(w, r)
+		})
+	}
+}
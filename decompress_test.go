@@ -0,0 +1,109 @@
+package fibre
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw":
+		w, _ = flate.NewWriter(&buf, flate.BestCompression)
+	}
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecompressMiddleware(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	handler := ws.DecompressMiddleware(DecompressConfig{MaxBytes: 1 << 20, MaxRatio: 50})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			ws.JsonStatusResponse(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		want     string
+	}{
+		{"plain", "", []byte("hello"), http.StatusOK, "hello"},
+		{"gzip", "gzip", compress(t, "gzip", []byte("hello")), http.StatusOK, "hello"},
+		{"deflate", "deflate", compress(t, "deflate", []byte("hello")), http.StatusOK, "hello"},
+		{"raw deflate", "deflate", compress(t, "raw", []byte("hello")), http.StatusOK, "hello"},
+		{"malformed", "gzip", []byte("not gzip"), http.StatusBadRequest, ""},
+		{"unsupported", "br", []byte("hello"), http.StatusUnsupportedMediaType, ""},
+		{"too large", "gzip", compress(t, "gzip", bytes.Repeat([]byte("a"), 2<<20)), http.StatusRequestEntityTooLarge, ""},
+		{"ratio", "gzip", compress(t, "gzip", bytes.Repeat([]byte("a"), 512<<10)), http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.name, status, tt.status)
+		}
+		if tt.want != "" && w.Body.String() != tt.want {
+			t.Errorf("%v returned unexpected body: got %v want %v", tt.name, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestDecompressProxy(t *testing.T) {
+	var encoding, body string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", ":0")
+	handler := ws.DecompressMiddleware(DecompressConfig{})(ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL}))
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(compress(t, "gzip", []byte("payload"))))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("proxy returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if encoding != "" || body != "payload" {
+		t.Errorf("upstream received wrong body: got %v %q want %q", encoding, body, "payload")
+	}
+
+	req = httptest.NewRequest("POST", "/", bytes.NewReader(compress(t, "gzip", []byte(strings.Repeat("a", 1<<20)))))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if status := w.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("proxy returned wrong status code for zip bomb: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+}
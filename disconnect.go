@@ -0,0 +1,60 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the non-standard status recorded when the
+// client goes away before a response is written.
+const StatusClientClosedRequest = 499
+
+// ClientGone reports whether the client behind r has disconnected, ie; the
+// request context was canceled rather than timed out.
+func ClientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// DisconnectMiddleware records requests aborted by the client in the
+// client_disconnects counter instead of treating them as failures.
+func (ws *WebService) DisconnectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ClientGone(r) {
+			ws.Metrics.Inc("client_disconnects")
+			return
+		}
+		next.ServeHTTP(w, r)
+		if ClientGone(r) {
+			ws.Metrics.Inc("client_disconnects")
+		}
+	})
+}
+
+// proxyErrorHandler replaces the default reverse proxy error handler, which
+// logs "context canceled" for every aborted request.
+func (ws *WebService) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if ClientGone(r) {
+		ws.Metrics.Inc("client_disconnects")
+		w.WriteHeader(StatusClientClosedRequest)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || errors.Is(err, errCompressionRatio) {
+		ws.Metrics.Inc("proxy_request_too_large")
+		ws.JsonStatusResponse(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errResponseTooLarge) {
+		ws.Metrics.Inc("proxy_response_too_large")
+	}
+	if ws.logs(LogError) {
+		log.Printf("proxy error: %v", err)
+	}
+	if ws.StrictJSON {
+		ws.jsonError(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
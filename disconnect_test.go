@@ -0,0 +1,53 @@
+package fibre
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientGone(t *testing.T) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ClientGone(req) {
+		t.Errorf("ClientGone returned true for a live request")
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	if !ClientGone(req.WithContext(ctx)) {
+		t.Errorf("ClientGone returned false for a canceled request")
+	}
+}
+
+func TestDisconnectMiddleware(t *testing.T) {
+	ws := new(WebService)
+	ws.Metrics = NewMetrics()
+
+	called := false
+	handler := ws.DisconnectMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req.WithContext(ctx))
+
+	if called {
+		t.Errorf("DisconnectMiddleware called the next handler for a disconnected client")
+	}
+
+	if got := ws.Metrics.Get("client_disconnects"); got != 1 {
+		t.Errorf("DisconnectMiddleware recorded wrong client_disconnects: got %v want %v", got, 1)
+	}
+}
@@ -1,12 +1,35 @@
 package main
 
 import (
+	"flag"
+	"log"
+
 	"github.com/lakesite/ls-config"
 	"github.com/lakesite/ls-fibre"
 )
 
 func main() {
+	selftest := flag.Bool("selftest", false, "boot, check health and smoke routes, then exit")
+	checkConfig := flag.Bool("check-config", false, "validate configuration, then exit")
+	flag.Parse()
+
 	address := config.Getenv("MAIN_HOST", "127.0.0.1") + ":" + config.Getenv("MAIN_PORT", "8080")
 	ws := fibre.NewWebService("main", address)
+
+	if *checkConfig {
+		if err := ws.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *selftest {
+		ws.SmokeRoutes = []string{"/"}
+		if err := ws.SelfTest(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ws.RunWebServer()
 }
@@ -4,17 +4,20 @@
 package fibre
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,11 +26,171 @@ import (
 // struct WebService holds a Router (*mux.Router), Instance name (string), 
 // Address (string), and optional Apikey (string).
 type WebService struct {
-	Router *mux.Router
+	Router  *mux.Router
+	Metrics *Metrics
+
+	// Backend, when set, serves the routes added with Handle ahead of
+	// Router, which handles everything else.
+	Backend       RouterBackend
+	backendRoutes []RouteInfo
 
 	Instance string
 	Address  string
 	Apikey   string
+
+	// Keys, when set, replaces Apikey with per client keys and tiers.
+	Keys *KeyStore
+
+	// AdminKey guards the Admin() router.
+	AdminKey string
+	admin    *mux.Router
+
+	// healthChecks are run by SelfTest, each within HealthCheckTimeout;
+	// SmokeRoutes are requested by it alongside /healthcheck, within
+	// SelfTestTimeout.
+	healthChecks       []HealthCheck
+	HealthCheckTimeout time.Duration
+	SmokeRoutes        []string
+	SelfTestTimeout    time.Duration
+
+	// logLevel and maintenance may be changed while serving, with
+	// SetLogLevel and SetMaintenance or through ConfigHandler, as may the
+	// rates of rateLimiters, those used by RateLimitMiddleware.
+	logLevel       atomic.Int32
+	maintenance    atomic.Pointer[MaintenanceMode]
+	rateLimitersMu sync.Mutex
+	rateLimiters   []*RateLimiter
+
+	// Requests, when set, keeps recent requests for RequestTraceHandler;
+	// see EnableRequestTrace.
+	Requests *RequestTrace
+
+	// loadgen serialises load runs from LoadGenHandler.
+	loadgen sync.Mutex
+
+	// docs holds route documentation attached with Document.
+	docs map[*mux.Route]RouteDoc
+
+	// SpanRecorder receives the client spans of proxies with Trace set;
+	// they are logged when it is nil.
+	SpanRecorder func(ProxySpan)
+
+	// MethodPolicy, when set, restricts the HTTP methods accepted before
+	// routing.
+	MethodPolicy *MethodPolicy
+
+	// Hardening, when set, refuses malformed and ambiguous requests before
+	// any other stage; see DefaultHardening.
+	Hardening *Hardening
+
+	// Rules, when set, rewrite and redirect requests before any Wrap
+	// middleware or routing; see CompileRules and LoadRules.
+	Rules *Rules
+
+	// ACME, when set, answers ACME HTTP-01 challenges before any Wrap
+	// middleware or routing.
+	ACME *ACMEConfig
+
+	// wrappers run before routing, outermost first.
+	wrappers []func(http.Handler) http.Handler
+
+	// StrictRoutes refuses to start the server when CheckRoutes finds
+	// duplicate or shadowed routes, rather than logging them.
+	StrictRoutes bool
+
+	// ReadTimeout and WriteTimeout bound each request on the server; zero
+	// means DefaultTimeout and a negative value disables the timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// PageCache caches rendered pages when set, and PageVariant selects the
+	// locale and theme a request is rendered for.
+	PageCache   *PageCache
+	PageVariant func(r *http.Request) (locale string, theme string)
+
+	// warming is set by EnableCacheWarming.
+	warming *CacheWarming
+
+	// PageAllowlist, when not empty, limits the pages PageHandler will
+	// render to those named.
+	PageAllowlist []string
+
+	// Minify selects response minification, applied to rendered pages and
+	// by MinifyMiddleware.
+	Minify *MinifyConfig
+
+	// StrictJSON answers every default handler (not found, method not
+	// allowed, panics and errors) with JSON, using ErrorEnvelope for
+	// errors, and never renders pages, for API only instances.
+	StrictJSON bool
+
+	// DevMode renders a diagnostic page for template errors, in place of
+	// InternalErrorHandler.  It should only be used in development.
+	DevMode bool
+
+	// SkipAbortedRenders skips template rendering for requests whose client
+	// has already disconnected.
+	SkipAbortedRenders bool
+
+	// Logins protects BasicAuthMiddleware and RecordLogin logins from
+	// brute force attempts.  Audit records logins, lockouts and admin
+	// changes; events are logged when it is nil.
+	Logins *LoginGuard
+	Audit  *AuditLog
+
+	// preflight is set by EnablePreflight, and preflightReport is its latest
+	// outcome.
+	preflightMu     sync.Mutex
+	preflight       *Preflight
+	preflightReport *PreflightReport
+
+	// Storage holds uploaded files for SaveUpload and DownloadHandler.
+	Storage Storage
+
+	// ShutdownNotice is sent to SSE clients and OnShutdownNotice hooks when
+	// shutdown begins; DefaultShutdownNotice is used when it is nil.
+	// ShutdownTimeout bounds the wait for requests to finish.
+	ShutdownNotice  *ShutdownNotice
+	ShutdownTimeout time.Duration
+	sseHubs         []*SSEHub
+	noticeHooks     []func(ShutdownNotice)
+
+	// startupHooks and shutdownHooks are registered with OnStartup and
+	// OnShutdown.
+	startupHooks  []Hook
+	shutdownHooks []Hook
+	stopOnce      sync.Once
+
+	// DrainDelay keeps serving for a while after shutdown begins, reporting
+	// "draining" from StatusHandler, so load balancers can move traffic
+	// away before the listener closes.
+	DrainDelay time.Duration
+	draining   atomic.Bool
+	started    time.Time
+	inFlight   atomic.Int64
+	streams    atomic.Int64
+
+	serverMu sync.Mutex
+	server   *http.Server
+
+	// NormalizeUnmatched names requests no route matched in logs and
+	// metrics, in place of UnmatchedRoute; NormalizeIDs is one choice.
+	// MaxRouteMetrics bounds the routes counted (DefaultMaxRouteMetrics
+	// when zero), against unbounded metric names.
+	NormalizeUnmatched func(path string) string
+	MaxRouteMetrics    int
+	routeCounters      routeCounters
+
+	// ProxyTransport sets connection pooling for proxy upstreams.  Proxies
+	// to the same host with the same settings share a transport.
+	ProxyTransport *TransportConfig
+	proxies        []ProxyConfig
+	transportsMu   sync.Mutex
+	transports     map[transportKey]*http.Transport
+
+	// upstreams records the health of the proxy upstreams called.
+	upstreamsMu sync.Mutex
+	upstreams   map[string]*UpstreamHealth
 }
 
 type ProxyOverride struct {
@@ -37,9 +200,58 @@ type ProxyOverride struct {
 }
 
 type ProxyConfig struct {
-	Path     string
-	Host     string
+	Path string
+	Host string
+
+	// Override replaces the path prefix Match with Path.
+	//
+	// Deprecated: use Rewrite.
 	Override ProxyOverride
+
+	// Rewrite, when set, rewrites the path and query sent upstream; rule
+	// redirects are ignored here.
+	Rewrite *Rules
+
+	// Trace starts a client span for each upstream call, continuing the
+	// caller's traceparent or beginning a new trace.
+	Trace bool
+
+	// MaxRequestBytes and MaxResponseBytes limit proxied bodies; zero is
+	// unlimited.  Larger requests are refused with 413, and larger upstream
+	// responses with 502.
+	MaxRequestBytes  int64
+	MaxResponseBytes int64
+
+	// TrustForwarded passes inbound Forwarded, X-Forwarded-* and X-Real-IP
+	// headers upstream; by default they are stripped so clients cannot
+	// spoof their address.  StripHeaders names further inbound headers to
+	// drop.
+	TrustForwarded bool
+	StripHeaders   []string
+
+	// Transport overrides ws.ProxyTransport for this proxy.
+	Transport *TransportConfig
+
+	// PreserveHost sends the inbound Host header upstream, rather than the
+	// upstream's own host.
+	PreserveHost bool
+
+	// Compression, when set, asks the upstream for gzip and serves each
+	// client the encoding it accepts.
+	Compression *ProxyCompression
+
+	// Auth, when set, sends these credentials upstream in place of the
+	// client's.
+	Auth *ProxyAuth
+
+	// Cache, when set, keeps upstream responses to serve while fresh and,
+	// when the upstream fails, stale.
+	Cache *ProxyCache
+
+	// RequestTransform and ResponseTransform, when set, rewrite JSON bodies
+	// sent to and received from the upstream; see FieldMapping.
+	RequestTransform  BodyTransform
+	ResponseTransform BodyTransform
 }
 
 func trimLeftChars(s string, n int) string {
@@ -53,11 +265,18 @@ func trimLeftChars(s string, n int) string {
 	return s[:0]
 }
 
-// LogMiddleware simply prints request URIs.
+// LogMiddleware simply prints request URIs, and the status and size of
+// their responses.
 func (ws *WebService) LogMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ws.logs(LogInfo) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		fmt.Printf("Got request URI: %s\n", r.RequestURI)
-		next.ServeHTTP(w, r)
+		sw := NewSizeWriter(w)
+		next.ServeHTTP(sw, r)
+		fmt.Printf("Sent %d bytes (status %d) for route: %s (URI: %s)\n", sw.Bytes, sw.Status, ws.RouteTemplate(sw, r), r.RequestURI)
 	})
 }
 
@@ -65,10 +284,12 @@ func (ws *WebService) LogMiddleware(next http.Handler) http.Handler {
 func (ws *WebService) APIKeyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		apik := r.Header.Get("api_key")
+		if ws.Keys != nil {
+			ws.serveWithKey(w, r, apik, next)
+			return
+		}
 		if len(apik) == 0 || apik != ws.Apikey {
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode("Invalid api_key")
+			ws.JsonStatusResponse(w, "Invalid api_key", http.StatusUnauthorized)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -78,13 +299,36 @@ func (ws *WebService) APIKeyMiddleware(next http.Handler) http.Handler {
 // JsonStatusResponse takees a response writer, response string and status,
 // and writes the status and encodes the response string.
 func (ws *WebService) JsonStatusResponse(w http.ResponseWriter, response string, status int) {
-	w.Header().Set("Content-Type", "application/json")
+	if ws.StrictJSON && status >= http.StatusBadRequest {
+		ws.jsonError(w, response, status)
+		return
+	}
+	ws.writeJSON(w, response, status)
+}
+
+// writeJSON encodes v with the given status and its Content-Length, using
+// a pooled buffer and encoder.
+func (ws *WebService) writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	jb := getJSONBuffer()
+	defer putJSONBuffer(jb)
+	if err := jb.enc.Encode(v); err != nil {
+		log.Printf("%v json encoding: %v", ws.Instance, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h["Content-Type"] = jsonContentType
+	h["Content-Length"] = []string{strconv.Itoa(jb.buf.Len())}
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(response)
+	w.Write(jb.buf.Bytes())
 }
 
 // NotFoundHandler provides a default not found handler for the instance.
 func (ws *WebService) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	if ws.StrictJSON {
+		ws.jsonError(w, "page not found", http.StatusNotFound)
+		return
+	}
 	w.WriteHeader(http.StatusNotFound)
 	io.WriteString(w, `404 page not found`)
 }
@@ -96,41 +340,104 @@ func (ws *WebService) FavicoHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "data:image/x-icon;base64,iVBORw0KGgoAAAANSUhEUgAAABAAAAAQEAYAAABPYyMiAAAABmJLR0T///////8JWPfcAAAACXBIWXMAAABIAAAASABGyWs+AAAAF0lEQVRIx2NgGAWjYBSMglEwCkbBSAcACBAAAeaR9cIAAAAASUVORK5CYII=\n")
 }
 
+// InternalErrorHandler provides a default internal server error response
+// for the instance.
+func (ws *WebService) InternalErrorHandler(w http.ResponseWriter, r *http.Request) {
+	if ws.StrictJSON {
+		ws.jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, `500 internal server error`)
+}
+
 // Home handler provides a default index handler for the instance.
 func (ws *WebService) HomeHandler(w http.ResponseWriter, r *http.Request) {
-	templateLocation := "web/" + ws.Instance + "/page/index.html"
-	baseTemplateLocation := "web/" + ws.Instance + "/templates/base.html"
-	tmpl, err := template.ParseFiles(templateLocation, baseTemplateLocation)
-	if err != nil {
-		ws.NotFoundHandler(w, r)
-	} else {
-		w.WriteHeader(http.StatusOK)
-		tmpl.ExecuteTemplate(w, "base", struct{ Data string }{Data: "data"})
-	}
+	ws.renderPage(w, r, "index")
 }
 
 // HealthCheckHandler provides a default health check response (in JSON) for the
-// instance.
+// instance.  It runs the registered health checks, answering 503 when a
+// critical check fails, with per check results for ?verbose (and their
+// errors for admin requests), plain text for clients preferring it unless
+// StrictJSON, and no body for HEAD.
 func (ws *WebService) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	io.WriteString(w, `{"alive": true}`)
+	verbose := r.URL.Query().Has("verbose")
+	if len(ws.healthChecks) == 0 && !verbose && (ws.StrictJSON || !prefersText(r)) {
+		w.Header()["Content-Type"] = jsonContentType
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			io.WriteString(w, `{"alive": true}`)
+		}
+		return
+	}
+	ws.healthReport(w, r, verbose)
 }
 
 // Generic handler for /page/<page>.html requests, which reads from the
 // root/web/<instance>/templates/<page>.html template.
 func (ws *WebService) PageHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	templateLocation := "web/" + ws.Instance + "/page/" + vars["page"] + ".html"
-	baseTemplateLocation := "web/" + ws.Instance + "/templates/base.html"
-	tmpl, err := template.ParseFiles(templateLocation, baseTemplateLocation)
-	if err != nil {
+	vars := Vars(r)
+	if !ws.pageAllowed(vars["page"]) {
+		ws.NotFoundHandler(w, r)
+		return
+	}
+	ws.renderPage(w, r, vars["page"])
+}
+
+// renderPage renders web/<instance>/page/<page>.html within the base
+// template, serving from and filling the page cache when one is configured.
+func (ws *WebService) renderPage(w http.ResponseWriter, r *http.Request, page string) {
+	if ws.StrictJSON {
 		ws.NotFoundHandler(w, r)
-	} else {
+		return
+	}
+	if ws.SkipAbortedRenders && ClientGone(r) {
+		ws.Metrics.Inc("client_disconnects")
+		return
+	}
+
+	key := ws.pageKey(r, page)
+	if body, surrogates, ok := ws.PageCache.Get(key); ok {
+		w.Header().Set("Surrogate-Key", strings.Join(surrogates, " "))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 		w.WriteHeader(http.StatusOK)
-		tmpl.ExecuteTemplate(w, "base", struct{ Data string }{Data: "data"})
+		w.Write(body)
+		return
 	}
+
+	templateLocation, err := ws.pageLocation(page)
+	if err != nil {
+		ws.NotFoundHandler(w, r)
+		return
+	}
+	if _, err := os.Stat(templateLocation); err != nil {
+		ws.NotFoundHandler(w, r)
+		return
+	}
+	baseTemplateLocation := "web/" + ws.Instance + "/templates/base.html"
+	files := []string{templateLocation, baseTemplateLocation}
+	tmpl, err := template.ParseFiles(files...)
+	if err != nil {
+		ws.templateError(w, r, page, files, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "base", struct{ Data string }{Data: "data"}); err != nil {
+		ws.templateError(w, r, page, files, err)
+		return
+	}
+	body := buf.Bytes()
+	if ws.Minify != nil && ws.Minify.HTML {
+		body = MinifyHTML(body)
+	}
+	surrogates := []string{ws.surrogateKey(templateLocation), ws.surrogateKey(baseTemplateLocation)}
+	ws.PageCache.Set(key, body, surrogates)
+	w.Header().Set("Surrogate-Key", strings.Join(surrogates, " "))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
 }
 
 func (ws *WebService) SetupProxy(config ProxyConfig) http.Handler {
@@ -139,9 +446,16 @@ func (ws *WebService) SetupProxy(config ProxyConfig) http.Handler {
 
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
+			sanitizeProxyRequest(req, config)
 			req.Header.Add("X-Forwarded-Host", req.Host)
 			req.Header.Add("X-Origin-Host", purl.Host)
-			req.Host = purl.Host
+			ensureRequestID(req)
+			if config.Compression != nil {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			if !config.PreserveHost {
+				req.Host = purl.Host
+			}
 			req.URL.Host = purl.Host
 			req.URL.Scheme = purl.Scheme
 
@@ -150,25 +464,54 @@ func (ws *WebService) SetupProxy(config ProxyConfig) http.Handler {
 					req.URL.Path = trimLeftChars(req.URL.Path, len(config.Override.Match)) + config.Override.Path
 				}
 			}
+			config.Rewrite.Apply(req)
 		},
 
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout: 5 * time.Second,
-			}).Dial,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+		Transport: &healthTransport{ws: ws, host: purl.Host, next: ws.proxyTransport(purl, config.Transport)},
+
+		ErrorHandler: ws.proxyErrorHandler,
 	}
-	return proxy
+	if config.Auth != nil {
+		proxy.Transport = &authTransport{ws: ws, auth: config.Auth, next: proxy.Transport}
+	}
+	if config.Trace {
+		proxy.Transport = &tracingTransport{ws: ws, next: proxy.Transport}
+	}
+	if config.Cache != nil {
+		proxy.Transport = &cacheTransport{ws: ws, cache: config.Cache, shared: config.Auth != nil, next: proxy.Transport}
+	}
+	var modify []func(*http.Response) error
+	if config.ResponseTransform != nil {
+		modify = append(modify, ws.transformProxyResponse(config.ResponseTransform, config.MaxResponseBytes))
+	}
+	if config.Compression != nil {
+		modify = append(modify, ws.compressProxyResponse(*config.Compression))
+	}
+	if config.MaxResponseBytes > 0 {
+		modify = append(modify, limitProxyResponse(config.MaxResponseBytes))
+	}
+	if len(modify) > 0 {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			for _, m := range modify {
+				if err := m(resp); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	handler := ws.limitProxyRequest(config, ws.transformProxyRequest(config, proxy))
+	if config.Compression != nil {
+		handler = negotiateCompression(handler)
+	}
+	return handler
 }
 
 func (ws *WebService) Proxy(config []ProxyConfig) {
 	for _, pc := range config {
-		proxy := ws.SetupProxy(pc)
-
-		ws.Router.HandleFunc(pc.Path, func(w http.ResponseWriter, r *http.Request) {
-			proxy.ServeHTTP(w, r)
-		})
+		ws.proxies = append(ws.proxies, pc)
+		ws.Router.Handle(pc.Path, ws.SetupProxy(pc))
 	}
 }
 
@@ -176,31 +519,99 @@ func (ws *WebService) Proxy(config []ProxyConfig) {
 // instance is a key that will be used in loading templates, static files, etc.
 // address is the host and port to listen on
 func NewWebService(instance string, address string) *WebService {
+	return NewWebServiceWithRouter(instance, address, nil)
+}
+
+// NewWebServiceWithRouter creates a web service whose Handle routes are
+// served by backend, such as NewServeMuxBackend(), falling back to the
+// gorilla/mux Router for the default handlers and everything else.
+func NewWebServiceWithRouter(instance string, address string, backend RouterBackend) *WebService {
 	r := mux.NewRouter()
 	ws := &WebService{
 		Instance: instance,
 		Address:  address,
 		Router:   r,
+		Backend:  backend,
+		Metrics:  NewMetrics(),
+		Logins:   NewLoginGuard(),
+
+		ReadTimeout:  DefaultTimeout,
+		WriteTimeout: DefaultTimeout,
 	}
 
 	r.NotFoundHandler = http.HandlerFunc(ws.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(ws.MethodNotAllowedHandler)
+	r.Use(routeMiddleware)
 	r.HandleFunc("/favicon.ico", ws.FavicoHandler)
 	r.HandleFunc("/", ws.HomeHandler)
 	r.HandleFunc("/healthcheck", ws.HealthCheckHandler)
 	r.HandleFunc("/page/{page}.html", ws.PageHandler)
+	if backend != nil {
+		backend.NotFound(r)
+	}
 
 	return ws
 }
 
+// Wrap adds middleware which runs before routing, so unlike Router.Use it
+// sees every request, including those no route matches.
+func (ws *WebService) Wrap(mw ...func(http.Handler) http.Handler) {
+	ws.wrappers = append(ws.wrappers, mw...)
+}
+
+// Handler returns the root handler for the instance's server: the router
+// behind the built in pre-routing stages and any Wrap middleware.
+func (ws *WebService) Handler() http.Handler {
+	var h http.Handler = ws.Router
+	if ws.Backend != nil {
+		h = ws.Backend
+	}
+	for i := len(ws.wrappers) - 1; i >= 0; i-- {
+		h = ws.wrappers[i](h)
+	}
+	if ws.Rules != nil {
+		h = ws.RulesMiddleware(ws.Rules)(h)
+	}
+	h = ws.maintenanceMiddleware(h)
+	if ws.ACME != nil {
+		h = ws.ACMEMiddleware(*ws.ACME)(h)
+	}
+	if ws.MethodPolicy != nil {
+		h = ws.MethodMiddleware(h)
+	}
+	if ws.Hardening != nil {
+		h = ws.HardeningMiddleware(*ws.Hardening)(h)
+	}
+	return ws.serveMiddleware(h)
+}
+
 // Creates a new net/http service with a WebService configuration,
-// then run the http.Server
+// then run the http.Server until SIGINT or SIGTERM, when it is shut down
+// gracefully.
 func (ws *WebService) RunWebServer() {
 	server := &http.Server{
-		Handler:      ws.Router,
+		Handler:      ws.Handler(),
 		Addr:         ws.Address,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		WriteTimeout: timeoutOrDefault(ws.WriteTimeout),
+		ReadTimeout:  timeoutOrDefault(ws.ReadTimeout),
+	}
+	if err := ws.checkRoutes(); err != nil {
+		log.Fatal(err)
 	}
+	if err := ws.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	ws.setServer(server)
+	done := make(chan struct{})
+	go ws.shutdownOnSignal(done)
+
 	fmt.Printf("%v serving on: %v.\n", ws.Instance, ws.Address)
-	log.Fatal(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		// the started subsystems are still shut down before exiting.
+		ctx, cancel := context.WithTimeout(context.Background(), orDefault(ws.ShutdownTimeout, DefaultShutdownTimeout))
+		ws.stop(ctx)
+		cancel()
+		log.Fatal(err)
+	}
+	<-done
 }
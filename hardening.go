@@ -0,0 +1,130 @@
+package fibre
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// singletonHeaders may appear once; duplicates with differing values are
+// refused, since a backend may read another value than fibre did.
+var singletonHeaders = []string{
+	"Authorization",
+	"Content-Length",
+	"Content-Type",
+	"Api_key",
+	"Admin_key",
+}
+
+// struct Hardening configures the protocol checks made on every request
+// before routing or proxying, protecting proxied backends from request
+// smuggling.  Limits of zero are not enforced.
+type Hardening struct {
+	// RejectAmbiguousLength refuses requests whose body length is
+	// ambiguous: Transfer-Encoding with Content-Length, more than one
+	// Content-Length, or a malformed one.
+	RejectAmbiguousLength bool
+
+	// MaxHeaders and MaxHeaderBytes bound the header values and their total
+	// size, names included, answered with 431.
+	MaxHeaders     int
+	MaxHeaderBytes int
+
+	// DenyTrace refuses TRACE and TRACK, which can echo credentials back to
+	// scripts.
+	DenyTrace bool
+
+	// NormalizeHeaders collapses repeated singleton headers (Content-Type,
+	// Authorization, api_key, ...) with the same value and refuses those
+	// with differing values.
+	NormalizeHeaders bool
+}
+
+// DefaultHardening enables every check, with limits well above what
+// browsers and API clients send.
+var DefaultHardening = Hardening{
+	RejectAmbiguousLength: true,
+	MaxHeaders:            100,
+	MaxHeaderBytes:        64 << 10,
+	DenyTrace:             true,
+	NormalizeHeaders:      true,
+}
+
+// ambiguousLength reports whether r's body length could be read differently
+// by another server.
+func ambiguousLength(r *http.Request) bool {
+	lengths := r.Header["Content-Length"]
+	if len(lengths) > 1 {
+		return true
+	}
+	if len(lengths) == 1 {
+		if len(r.TransferEncoding) > 0 || len(r.Header["Transfer-Encoding"]) > 0 {
+			return true
+		}
+		if strings.Trim(lengths[0], "0123456789") != "" {
+			return true
+		}
+		if _, err := strconv.ParseInt(lengths[0], 10, 64); err != nil {
+			return true
+		}
+	}
+	for _, te := range r.TransferEncoding {
+		if te != "chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// HardeningMiddleware returns middleware refusing requests which fail the
+// checks in h, counted in hardening_rejected.
+func (ws *WebService) HardeningMiddleware(h Hardening) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h.DenyTrace && (r.Method == "TRACE" || r.Method == "TRACK") {
+				ws.Metrics.Inc("hardening_rejected")
+				ws.MethodNotAllowedHandler(w, r)
+				return
+			}
+			if h.RejectAmbiguousLength && ambiguousLength(r) {
+				ws.Metrics.Inc("hardening_rejected")
+				ws.textError(w, "Ambiguous request body length", http.StatusBadRequest)
+				return
+			}
+
+			if h.MaxHeaders > 0 || h.MaxHeaderBytes > 0 {
+				count, size := 0, 0
+				for k, vs := range r.Header {
+					count += len(vs)
+					for _, v := range vs {
+						size += len(k) + len(v)
+					}
+				}
+				if (h.MaxHeaders > 0 && count > h.MaxHeaders) || (h.MaxHeaderBytes > 0 && size > h.MaxHeaderBytes) {
+					ws.Metrics.Inc("hardening_rejected")
+					ws.textError(w, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge)
+					return
+				}
+			}
+
+			if h.NormalizeHeaders {
+				for _, name := range singletonHeaders {
+					vs := r.Header[name]
+					if len(vs) < 2 {
+						continue
+					}
+					for _, v := range vs[1:] {
+						if strings.TrimSpace(v) != strings.TrimSpace(vs[0]) {
+							ws.Metrics.Inc("hardening_rejected")
+							ws.textError(w, "Conflicting "+name+" headers", http.StatusBadRequest)
+							return
+						}
+					}
+					r.Header[name] = vs[:1]
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
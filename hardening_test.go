@@ -0,0 +1,61 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHardening(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	h := DefaultHardening
+	h.MaxHeaders = 5
+	ws.Hardening = &h
+	var contentType []string
+	ws.Router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header["Content-Type"]
+	})
+	handler := ws.Handler()
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string][]string
+		chunked bool
+		status  int
+	}{
+		{"plain", "POST", map[string][]string{"Content-Length": {"0"}}, false, http.StatusOK},
+		{"chunked", "POST", nil, true, http.StatusOK},
+		{"trace", "TRACE", nil, false, http.StatusMethodNotAllowed},
+		{"chunked with length", "POST", map[string][]string{"Content-Length": {"4"}}, true, http.StatusBadRequest},
+		{"two lengths", "POST", map[string][]string{"Content-Length": {"4", "5"}}, false, http.StatusBadRequest},
+		{"bad length", "POST", map[string][]string{"Content-Length": {"+4"}}, false, http.StatusBadRequest},
+		{"too many headers", "POST", map[string][]string{"X-A": {"1", "2", "3", "4", "5", "6"}}, false, http.StatusRequestHeaderFieldsTooLarge},
+		{"conflicting auth", "POST", map[string][]string{"Authorization": {"Bearer a", "Bearer b"}}, false, http.StatusBadRequest},
+		{"repeated type", "POST", map[string][]string{"Content-Type": {"text/plain", "text/plain"}}, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/upload", strings.NewReader(""))
+		req.Header = tt.headers
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if tt.chunked {
+			req.TransferEncoding = []string{"chunked"}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.name, status, tt.status)
+		}
+	}
+
+	if len(contentType) != 1 {
+		t.Errorf("repeated Content-Type was not collapsed: got %v", contentType)
+	}
+	if got := ws.Metrics.Get("hardening_rejected"); got != 6 {
+		t.Errorf("hardening_rejected counted wrong: got %v want %v", got, 6)
+	}
+}
@@ -0,0 +1,150 @@
+package fibre
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckTimeout bounds each health check when
+// ws.HealthCheckTimeout is unset.
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// struct HealthCheck is a named check run by the health and self test
+// endpoints.  A failing Critical check marks the instance unhealthy.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// struct HealthResult is the outcome of one HealthCheck.
+type HealthResult struct {
+	Name     string        `json:"name"`
+	Critical bool          `json:"critical"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AddHealthCheck registers a health check.
+func (ws *WebService) AddHealthCheck(name string, critical bool, check func(ctx context.Context) error) {
+	ws.healthChecks = append(ws.healthChecks, HealthCheck{Name: name, Critical: critical, Check: check})
+}
+
+// RunHealthChecks runs every registered check in order, each within
+// ws.HealthCheckTimeout, returning their results and whether all critical
+// checks passed.  A check which times out has failed.
+func (ws *WebService) RunHealthChecks(ctx context.Context) ([]HealthResult, bool) {
+	healthy := true
+	timeout := orDefault(ws.HealthCheckTimeout, DefaultHealthCheckTimeout)
+	results := make([]HealthResult, 0, len(ws.healthChecks))
+	for _, hc := range ws.healthChecks {
+		start := time.Now()
+		err := runHook(ctx, Hook{Name: hc.Name, Timeout: timeout, Run: hc.Check})
+		result := HealthResult{Name: hc.Name, Critical: hc.Critical, OK: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			result.Error = err.Error()
+			if hc.Critical {
+				healthy = false
+			}
+		}
+		results = append(results, result)
+	}
+	return results, healthy
+}
+
+// struct HealthReport is the verbose HealthCheckHandler response.
+type HealthReport struct {
+	Alive  bool           `json:"alive"`
+	Checks []HealthResult `json:"checks,omitempty"`
+}
+
+// prefersText reports whether r's Accept header ranks text/plain above
+// JSON.
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	text, json := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch strings.TrimSpace(mediaType) {
+		case "text/plain":
+			text = q
+		case "application/json", "*/*":
+			json = max(json, q)
+		}
+	}
+	return text > 0 && text > json
+}
+
+// healthReport runs the health checks and writes their outcome for
+// HealthCheckHandler.
+func (ws *WebService) healthReport(w http.ResponseWriter, r *http.Request, verbose bool) {
+	results, healthy := ws.RunHealthChecks(r.Context())
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+		ws.Metrics.Inc("health_failures")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	// check errors can name files and hosts, so are only shown to admins.
+	if !ws.isAdmin(r) {
+		for i := range results {
+			results[i].Error, results[i].Duration = "", 0
+		}
+	}
+
+	if !ws.StrictJSON && prefersText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if r.Method == http.MethodHead {
+			return
+		}
+		if healthy {
+			io.WriteString(w, "ok\n")
+		} else {
+			io.WriteString(w, "unhealthy\n")
+		}
+		if verbose {
+			for _, res := range results {
+				state := "ok"
+				if !res.OK {
+					state = strings.TrimSpace("FAIL " + res.Error)
+					if !res.Critical {
+						state = strings.TrimSpace("WARN " + res.Error)
+					}
+				}
+				if res.Duration > 0 {
+					state += fmt.Sprintf(" (%v)", res.Duration.Round(time.Microsecond))
+				}
+				fmt.Fprintf(w, "%s: %s\n", res.Name, state)
+			}
+		}
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header()["Content-Type"] = jsonContentType
+		w.WriteHeader(status)
+		return
+	}
+	report := HealthReport{Alive: healthy}
+	if verbose {
+		report.Checks = results
+	}
+	ws.writeJSON(w, report, status)
+}
@@ -0,0 +1,138 @@
+package fibre
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthRequest(ws *WebService, method, target, accept string, admin bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if admin {
+		req.Header.Set("admin_key", ws.AdminKey)
+	}
+	rr := httptest.NewRecorder()
+	ws.HealthCheckHandler(rr, req)
+	return rr
+}
+
+func TestHealthCheckHandlerChecks(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:8080")
+	ws.AddHealthCheck("cache", false, func(ctx context.Context) error { return errors.New("cold") })
+
+	rr := healthRequest(ws, "GET", "/healthcheck", "", false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"alive":true}` {
+		t.Errorf("handler returned unexpected body: got %v", body)
+	}
+
+	ws.AddHealthCheck("database", true, func(ctx context.Context) error { return errors.New("unreachable") })
+	rr = healthRequest(ws, "GET", "/healthcheck?verbose", "", false)
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("handler returned wrong Cache-Control: got %v want no-store", cc)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("handler returned invalid JSON: %v", err)
+	}
+	if report.Alive || len(report.Checks) != 2 || report.Checks[1].OK || report.Checks[1].Error != "" {
+		t.Errorf("handler returned unexpected report: %+v", report)
+	}
+
+	// check errors are only shown to admin requests.
+	ws.AdminKey = "secret"
+	rr = healthRequest(ws, "GET", "/healthcheck?verbose", "", true)
+	report = HealthReport{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("handler returned invalid JSON: %v", err)
+	}
+	if len(report.Checks) != 2 || report.Checks[1].Error != "unreachable" {
+		t.Errorf("handler returned unexpected admin report: %+v", report)
+	}
+
+	rr = healthRequest(ws, "HEAD", "/healthcheck", "", false)
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("HEAD returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("HEAD returned a body: %v", rr.Body.String())
+	}
+}
+
+func TestHealthCheckHandlerText(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:8080")
+
+	rr := healthRequest(ws, "GET", "/healthcheck", "text/plain", false)
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("handler returned wrong content type: got %v want text/plain", ct)
+	}
+	if body := rr.Body.String(); body != "ok\n" {
+		t.Errorf("handler returned unexpected body: got %q want %q", body, "ok\n")
+	}
+
+	ws.AddHealthCheck("database", true, func(ctx context.Context) error { return errors.New("unreachable") })
+	rr = healthRequest(ws, "GET", "/healthcheck?verbose", "application/json;q=0.5, text/plain", false)
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if body := rr.Body.String(); body != "unhealthy\ndatabase: FAIL\n" {
+		t.Errorf("handler returned unexpected body: %q", body)
+	}
+	ws.AdminKey = "secret"
+	rr = healthRequest(ws, "GET", "/healthcheck?verbose", "text/plain", true)
+	if body := rr.Body.String(); !strings.HasPrefix(body, "unhealthy\ndatabase: FAIL unreachable (") {
+		t.Errorf("handler returned unexpected admin body: %q", body)
+	}
+
+	// text/plain is served when ranked above JSON, and JSON otherwise.
+	rr = healthRequest(ws, "GET", "/healthcheck", "text/html,text/plain;q=0.9,*/*;q=0.8", false)
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("handler ignored preferred text/plain: got %v", rr.Header().Get("Content-Type"))
+	}
+	rr = healthRequest(ws, "GET", "/healthcheck", "*/*", false)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want application/json", ct)
+	}
+
+	ws.StrictJSON = true
+	rr = healthRequest(ws, "GET", "/healthcheck", "text/plain", false)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler returned wrong content type with StrictJSON: got %v want application/json", ct)
+	}
+}
+
+func TestRunHealthChecksTimeout(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:8080")
+	ws.HealthCheckTimeout = 10 * time.Millisecond
+	ws.AddHealthCheck("hung", true, func(ctx context.Context) error { select {} })
+	ws.AddHealthCheck("cache", false, func(ctx context.Context) error { return nil })
+
+	done := make(chan struct{})
+	var results []HealthResult
+	var healthy bool
+	go func() {
+		results, healthy = ws.RunHealthChecks(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("RunHealthChecks blocked on a hung check")
+	}
+	if healthy || len(results) != 2 || results[0].OK || !results[1].OK {
+		t.Errorf("RunHealthChecks returned unexpected results for a hung check: %+v", results)
+	}
+}
@@ -0,0 +1,98 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// DefaultHookTimeout bounds hooks registered without a Timeout.
+const DefaultHookTimeout = 10 * time.Second
+
+// struct Hook is a named startup or shutdown step.  Hooks run one at a time,
+// lowest Priority first and in registration order among equals, each given
+// Timeout (DefaultHookTimeout when zero) to return.  Shutdown hooks are
+// ordered the same way, not in reverse, so their priorities give the
+// teardown order.  They also run when a startup hook fails, and must cope
+// with their subsystem not having started.
+type Hook struct {
+	Name     string
+	Priority int
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// OnStartup registers h to run before the server starts listening.
+func (ws *WebService) OnStartup(h Hook) {
+	ws.startupHooks = append(ws.startupHooks, h)
+}
+
+// OnShutdown registers h to run once the server has stopped serving, after
+// in flight requests have finished or the shutdown timeout has passed.
+func (ws *WebService) OnShutdown(h Hook) {
+	ws.shutdownHooks = append(ws.shutdownHooks, h)
+}
+
+// sortedHooks returns hooks in the order they run.
+func sortedHooks(hooks []Hook) []Hook {
+	sorted := append([]Hook(nil), hooks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	return sorted
+}
+
+// runHook runs h under its timeout, giving up on hooks which ignore ctx.
+func runHook(ctx context.Context, h Hook) error {
+	if h.Run == nil {
+		return errors.New("no Run func")
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start runs the startup hooks, stopping at the first to fail and then
+// running the shutdown hooks to stop the subsystems already started.
+// RunWebServer calls it before listening.
+func (ws *WebService) Start(ctx context.Context) error {
+	for _, h := range sortedHooks(ws.startupHooks) {
+		start := time.Now()
+		if err := runHook(ctx, h); err != nil {
+			return errors.Join(fmt.Errorf("fibre: startup hook %v: %w", h.Name, err), ws.stop(ctx))
+		}
+		log.Printf("%v started %v in %v", ws.Instance, h.Name, time.Since(start))
+	}
+	return nil
+}
+
+// stop runs the shutdown hooks, once, returning their errors.  Hooks run even
+// if the shutdown context has expired, each under its own timeout.
+func (ws *WebService) stop(ctx context.Context) error {
+	var errs []error
+	ws.stopOnce.Do(func() {
+		ctx = context.WithoutCancel(ctx)
+		for _, h := range sortedHooks(ws.shutdownHooks) {
+			if err := runHook(ctx, h); err != nil {
+				log.Printf("%v shutdown hook %v: %v", ws.Instance, h.Name, err)
+				errs = append(errs, fmt.Errorf("fibre: shutdown hook %v: %w", h.Name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
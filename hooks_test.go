@@ -0,0 +1,83 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	var order []string
+	hook := func(name string, priority int) Hook {
+		return Hook{Name: name, Priority: priority, Run: func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+	ws.OnStartup(hook("cache", 20))
+	ws.OnStartup(hook("database", 10))
+	ws.OnStartup(hook("jobs", 20))
+	ws.OnShutdown(hook("jobs", 0))
+	ws.OnShutdown(hook("database", 10))
+
+	if err := ws.Start(context.Background()); err != nil {
+		t.Fatalf("Start returned an error: %v", err)
+	}
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned an error: %v", err)
+	}
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second Shutdown returned an error: %v", err)
+	}
+
+	want := []string{"database", "cache", "jobs", "jobs", "database"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("hooks ran in wrong order: got %v want %v", order, want)
+	}
+}
+
+func TestHookErrors(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ran, stopped := false, false
+	ws.OnStartup(Hook{Name: "listener", Run: func(ctx context.Context) error {
+		return errors.New("address in use")
+	}})
+	ws.OnStartup(Hook{Name: "after", Priority: 1, Run: func(ctx context.Context) error {
+		ran = true
+		return nil
+	}})
+	ws.OnShutdown(Hook{Name: "listener", Run: func(ctx context.Context) error {
+		stopped = true
+		return nil
+	}})
+	if err := ws.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "listener") {
+		t.Errorf("Start returned wrong error: got %v", err)
+	}
+	if ran {
+		t.Errorf("Start ran hooks after a failure")
+	}
+	if !stopped {
+		t.Errorf("Start did not run the shutdown hooks after a failure")
+	}
+
+	ws = NewWebService("test", ":0")
+	ws.OnShutdown(Hook{Name: "stuck", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		select {}
+	}})
+	ws.OnShutdown(Hook{Name: "pool", Run: func(ctx context.Context) error {
+		ran = true
+		return nil
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ws.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown returned wrong error: got %v want %v", err, context.DeadlineExceeded)
+	}
+	if !ran {
+		t.Errorf("Shutdown skipped hooks after a timeout")
+	}
+}
@@ -0,0 +1,326 @@
+package fibre
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMaxImageDimension bounds the width and height of resized images.
+const DefaultMaxImageDimension = 2048
+
+// maxSourcePixels guards against decoding huge originals.
+const maxSourcePixels = 50 * 1000 * 1000
+
+// struct ImageConfig configures the on demand image service.
+type ImageConfig struct {
+	// Source is the directory originals are read from, defaulting to
+	// web/<instance>/static.
+	Source string
+
+	// CacheDir stores resized images on disk; results are not cached when
+	// empty.
+	CacheDir string
+
+	// Secret signs image parameters.  When empty signatures are not checked,
+	// which should only be used in development.
+	Secret []byte
+
+	MaxDimension int
+}
+
+// struct ImageParams describes a resize, parsed from a path segment such as
+// "w200,h100,crop,q80".
+type ImageParams struct {
+	Width   int
+	Height  int
+	Crop    bool
+	Quality int
+}
+
+// ParseImageParams parses a comma separated resize specification.
+func ParseImageParams(s string, max int) (ImageParams, error) {
+	p := ImageParams{Quality: 85}
+	for _, tok := range strings.Split(s, ",") {
+		var err error
+		switch {
+		case tok == "crop":
+			p.Crop = true
+		case tok == "fit":
+			p.Crop = false
+		case strings.HasPrefix(tok, "w"):
+			p.Width, err = strconv.Atoi(tok[1:])
+		case strings.HasPrefix(tok, "h"):
+			p.Height, err = strconv.Atoi(tok[1:])
+		case strings.HasPrefix(tok, "q"):
+			p.Quality, err = strconv.Atoi(tok[1:])
+		default:
+			err = errors.New("unknown image parameter " + tok)
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > max || p.Height > max || (p.Width == 0 && p.Height == 0) {
+		return p, errors.New("invalid image dimensions")
+	}
+	if p.Crop && (p.Width == 0 || p.Height == 0) {
+		return p, errors.New("crop requires width and height")
+	}
+	if p.Quality < 1 || p.Quality > 100 {
+		return p, errors.New("invalid image quality")
+	}
+	return p, nil
+}
+
+// containedPath joins name onto root, rejecting names which are absolute,
+// contain null bytes or would escape root once cleaned.
+func containedPath(root, name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) || strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", errors.New("invalid path")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errors.New("invalid path")
+		}
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+func signImage(secret []byte, params, name string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(params + "/" + name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignImageURL returns a signed /img/ URL for name resized with params.
+func (cfg ImageConfig) SignImageURL(params, name string) string {
+	u := "/img/" + params + "/" + name
+	if len(cfg.Secret) == 0 {
+		return u
+	}
+	return u + "?s=" + signImage(cfg.Secret, params, name)
+}
+
+// resize scales src to the dimensions in p, averaging source pixels when
+// shrinking.  Sizes derived from the source's aspect ratio are kept within
+// limit.
+func resize(src image.Image, p ImageParams, limit int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	w, h := p.Width, p.Height
+
+	if p.Crop {
+		// trim the source to the target aspect ratio around its centre,
+		// keeping at least a pixel for extreme ratios.
+		if sw*h > sh*w {
+			cw := max(sh*w/h, 1)
+			b.Min.X += (sw - cw) / 2
+			b.Max.X = b.Min.X + cw
+		} else {
+			ch := max(sw*h/w, 1)
+			b.Min.Y += (sh - ch) / 2
+			b.Max.Y = b.Min.Y + ch
+		}
+		sw, sh = b.Dx(), b.Dy()
+	} else {
+		switch {
+		case w == 0:
+			w = sw * h / sh
+		case h == 0:
+			h = sh * w / sw
+		case sw*h > sh*w:
+			h = sh * w / sw
+		default:
+			w = sw * h / sh
+		}
+		// only the requested size is bounded, so scale a derived side
+		// down to limit, keeping the aspect ratio.
+		if w > limit {
+			w, h = limit, h*limit/w
+		}
+		if h > limit {
+			w, h = w*limit/h, limit
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := rgba.PixOffset(sx, sy)
+					r += uint32(rgba.Pix[i])
+					g += uint32(rgba.Pix[i+1])
+					bl += uint32(rgba.Pix[i+2])
+					a += uint32(rgba.Pix[i+3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func encodeImage(buf *bytes.Buffer, img image.Image, format string, quality int) (string, error) {
+	switch format {
+	case "jpeg":
+		return "image/jpeg", jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return "image/gif", gif.Encode(buf, img, nil)
+	}
+	return "image/png", png.Encode(buf, img)
+}
+
+// ImageHandler serves /img/{params}/{path} requests, resizing images from
+// cfg.Source on demand.
+func (ws *WebService) ImageHandler(cfg ImageConfig) http.Handler {
+	if cfg.Source == "" {
+		cfg.Source = "web/" + ws.Instance + "/static"
+	}
+	if cfg.MaxDimension == 0 {
+		cfg.MaxDimension = DefaultMaxImageDimension
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := Vars(r)
+		params, name := vars["params"], vars["path"]
+
+		if len(cfg.Secret) > 0 {
+			sig := r.URL.Query().Get("s")
+			if !hmac.Equal([]byte(sig), []byte(signImage(cfg.Secret, params, name))) {
+				ws.textError(w, "invalid signature", http.StatusForbidden)
+				return
+			}
+		}
+
+		p, err := ParseImageParams(params, cfg.MaxDimension)
+		if err != nil {
+			ws.textError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		source, err := containedPath(cfg.Source, name)
+		if err != nil {
+			ws.NotFoundHandler(w, r)
+			return
+		}
+		fi, err := os.Stat(source)
+		if err != nil || fi.IsDir() {
+			ws.NotFoundHandler(w, r)
+			return
+		}
+
+		// only successful responses are cacheable, errors may be transient.
+		immutable := "public, max-age=31536000"
+
+		var cached string
+		if cfg.CacheDir != "" {
+			sum := sha256.Sum256([]byte(params + "/" + name + "/" + fi.ModTime().String()))
+			cached = filepath.Join(cfg.CacheDir, hex.EncodeToString(sum[:]))
+			if f, err := os.Open(cached); err == nil {
+				defer f.Close()
+				w.Header().Set("Cache-Control", immutable)
+				http.ServeContent(w, r, path.Base(name), fi.ModTime(), f)
+				return
+			}
+		}
+
+		f, err := os.Open(source)
+		if err != nil {
+			ws.NotFoundHandler(w, r)
+			return
+		}
+		var src image.Image
+		cfgImg, format, err := image.DecodeConfig(f)
+		if err == nil && cfgImg.Width*cfgImg.Height > maxSourcePixels {
+			err = errors.New("image too large")
+		}
+		if err == nil {
+			f.Seek(0, 0)
+			src, _, err = image.Decode(f)
+		}
+		f.Close()
+		if err != nil {
+			ws.textError(w, "unsupported image", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var buf bytes.Buffer
+		contentType, err := encodeImage(&buf, resize(src, p, cfg.MaxDimension), format, p.Quality)
+		if err != nil {
+			ws.textError(w, "image encoding failed", http.StatusInternalServerError)
+			return
+		}
+
+		if cached != "" {
+			ws.cacheImage(cfg.CacheDir, cached, buf.Bytes())
+		}
+
+		w.Header().Set("Cache-Control", immutable)
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(buf.Bytes()))
+	})
+}
+
+// cacheImage writes b to name via a temporary file, so concurrent requests
+// never serve a partial image.
+func (ws *WebService) cacheImage(dir, name string, b []byte) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp.Name(), name) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Images registers the image service under /img/.
+func (ws *WebService) Images(cfg ImageConfig) *mux.Route {
+	return ws.Router.Handle("/img/{params}/{path:.+}", ws.ImageHandler(cfg))
+}
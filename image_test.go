@@ -0,0 +1,135 @@
+package fibre
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func writeTestImage(t *testing.T, dir string) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseImageParams(t *testing.T) {
+	p, err := ParseImageParams("w200,h100,crop,q70", DefaultMaxImageDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Width != 200 || p.Height != 100 || !p.Crop || p.Quality != 70 {
+		t.Errorf("ParseImageParams returned unexpected params: %+v", p)
+	}
+
+	for _, bad := range []string{"", "w0", "w9999", "crop,w10", "x1", "w10,q0"} {
+		if _, err := ParseImageParams(bad, DefaultMaxImageDimension); err == nil {
+			t.Errorf("ParseImageParams accepted invalid params %q", bad)
+		}
+	}
+}
+
+func TestImageHandler(t *testing.T) {
+	source := t.TempDir()
+	writeTestImage(t, source)
+
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	cfg := ImageConfig{Source: source, CacheDir: t.TempDir(), Secret: []byte("secret")}
+	ws.Images(cfg)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", cfg.SignImageURL("w20", "a.png"), nil)
+		w := httptest.NewRecorder()
+		ws.Router.ServeHTTP(w, req)
+
+		if status := w.Code; status != http.StatusOK {
+			t.Fatalf("ImageHandler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc == "" {
+			t.Errorf("ImageHandler did not mark a resized image cacheable")
+		}
+
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 10 {
+			t.Errorf("ImageHandler returned unexpected size: got %vx%v want 20x10", b.Dx(), b.Dy())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/img/w20/a.png?s=bad", nil)
+	w := httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusForbidden {
+		t.Errorf("ImageHandler returned wrong status code for a bad signature: got %v want %v", status, http.StatusForbidden)
+	}
+
+	if err := os.WriteFile(filepath.Join(source, "b.png"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("GET", cfg.SignImageURL("w20", "b.png"), nil)
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusUnsupportedMediaType {
+		t.Errorf("ImageHandler returned wrong status code for a bad image: got %v want %v", status, http.StatusUnsupportedMediaType)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("ImageHandler marked an error cacheable: got %q", cc)
+	}
+}
+
+func TestContainedPath(t *testing.T) {
+	for _, bad := range []string{"", "../a.png", "a/../../b", "/etc/passwd", "a\x00b", "a\\b"} {
+		if _, err := containedPath("static", bad); err == nil {
+			t.Errorf("containedPath accepted %q", bad)
+		}
+	}
+
+	if p, err := containedPath("static", "img/a.png"); err != nil || p != filepath.Join("static", "img", "a.png") {
+		t.Errorf("containedPath returned unexpected path: got %v, %v", p, err)
+	}
+}
+
+func TestResizeExtremeCrop(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for _, p := range []ImageParams{{Width: 2048, Height: 1, Crop: true}, {Width: 1, Height: 2048, Crop: true}} {
+		dst := resize(src, p, DefaultMaxImageDimension)
+		if b := dst.Bounds(); b.Dx() != p.Width || b.Dy() != p.Height {
+			t.Errorf("resize returned unexpected size: got %vx%v want %vx%v", b.Dx(), b.Dy(), p.Width, p.Height)
+		}
+	}
+}
+
+func TestResizeExtremeAspect(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 10))
+	for _, p := range []ImageParams{{Height: 64}, {Width: 256, Height: 64}} {
+		dst := resize(src, p, 256)
+		if b := dst.Bounds(); b.Dx() != 256 || b.Dy() != 2 {
+			t.Errorf("resize returned unexpected size for %+v: got %vx%v want 256x2", p, b.Dx(), b.Dy())
+		}
+	}
+
+	src = image.NewRGBA(image.Rect(0, 0, 10, 1000))
+	if b := resize(src, ImageParams{Width: 64}, 256).Bounds(); b.Dx() != 2 || b.Dy() != 256 {
+		t.Errorf("resize returned unexpected size: got %vx%v want 2x256", b.Dx(), b.Dy())
+	}
+}
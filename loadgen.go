@@ -0,0 +1,200 @@
+package fibre
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxLoadConcurrency = 64
+	maxLoadDuration    = time.Minute
+
+	// maxLoadSamples bounds the latencies kept for percentiles; past it a
+	// uniform sample of the run is kept.
+	maxLoadSamples = 10000
+)
+
+// struct LoadRequest configures a synthetic load run.
+type LoadRequest struct {
+	Routes      []string `json:"routes"`
+	Method      string   `json:"method"`
+	Concurrency int      `json:"concurrency"`
+	Duration    string   `json:"duration"`
+}
+
+// struct LoadReport summarises a load run.  Latencies are in milliseconds.
+type LoadReport struct {
+	Requests int                `json:"requests"`
+	Errors   int                `json:"errors"`
+	Statuses map[int]int        `json:"statuses"`
+	Duration string             `json:"duration"`
+	RPS      float64            `json:"rps"`
+	Latency  map[string]float64 `json:"latency_ms"`
+}
+
+// loadWriter discards the response, keeping only the status.
+type loadWriter struct {
+	header http.Header
+	status int
+}
+
+func (lw *loadWriter) Header() http.Header {
+	return lw.header
+}
+
+func (lw *loadWriter) Write(p []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (lw *loadWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// GenerateLoad drives req.Routes in process through ws.Handler() for the
+// requested duration and concurrency, or until ctx is done, returning
+// latency percentiles.  Its requests are left out of the instance's
+// request metrics.
+func (ws *WebService) GenerateLoad(ctx context.Context, req LoadRequest) LoadReport {
+	duration, _ := time.ParseDuration(req.Duration)
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	handler := ws.Handler()
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, loadContextKey, true), duration)
+	defer cancel()
+	var (
+		mu        sync.Mutex
+		requests  int
+		slowest   time.Duration
+		latencies = make([]time.Duration, 0, maxLoadSamples)
+		statuses  = make(map[int]int)
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for c := 0; c < req.Concurrency; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := c; ctx.Err() == nil; i++ {
+				r, err := http.NewRequestWithContext(ctx, method, req.Routes[i%len(req.Routes)], nil)
+				if err != nil {
+					return
+				}
+				r.RemoteAddr = "127.0.0.1:0"
+				r.Header.Set("User-Agent", "fibre-loadgen")
+				lw := &loadWriter{header: make(http.Header)}
+				t := time.Now()
+				handler.ServeHTTP(lw, r)
+				elapsed := time.Since(t)
+				if lw.status == 0 {
+					lw.status = http.StatusOK
+				}
+
+				mu.Lock()
+				requests++
+				slowest = max(slowest, elapsed)
+				if len(latencies) < maxLoadSamples {
+					latencies = append(latencies, elapsed)
+				} else if j := rand.IntN(requests); j < maxLoadSamples {
+					latencies[j] = elapsed
+				}
+				statuses[lw.status]++
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report := LoadReport{
+		Requests: requests,
+		Statuses: statuses,
+		Duration: elapsed.String(),
+		RPS:      float64(requests) / elapsed.Seconds(),
+		Latency: map[string]float64{
+			"p50": percentile(latencies, 0.50),
+			"p90": percentile(latencies, 0.90),
+			"p99": percentile(latencies, 0.99),
+			"max": milliseconds(slowest),
+		},
+	}
+	for status, n := range statuses {
+		if status >= 500 {
+			report.Errors += n
+		}
+	}
+	return report
+}
+
+// LoadGenHandler accepts a JSON LoadRequest and responds with its
+// LoadReport.  Only one run may be in progress at a time.
+func (ws *WebService) LoadGenHandler(w http.ResponseWriter, r *http.Request) {
+	var req LoadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		ws.JsonStatusResponse(w, "Invalid load request", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 || duration > maxLoadDuration {
+		ws.JsonStatusResponse(w, "duration must be between 0 and "+maxLoadDuration.String(), http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency <= 0 || req.Concurrency > maxLoadConcurrency {
+		ws.JsonStatusResponse(w, "concurrency must be between 1 and 64", http.StatusBadRequest)
+		return
+	}
+	if len(req.Routes) == 0 {
+		ws.JsonStatusResponse(w, "no routes given", http.StatusBadRequest)
+		return
+	}
+	for _, route := range req.Routes {
+		if !strings.HasPrefix(route, "/") || strings.HasPrefix(route, "/debug/") || strings.HasPrefix(route, "/admin/") {
+			ws.JsonStatusResponse(w, "invalid route "+route, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !ws.loadgen.TryLock() {
+		ws.JsonStatusResponse(w, "A load run is already in progress", http.StatusConflict)
+		return
+	}
+	defer ws.loadgen.Unlock()
+
+	ws.writeJSON(w, ws.GenerateLoad(r.Context(), req), http.StatusOK)
+}
+
+// EnableLoadGen registers LoadGenHandler at /debug/loadgen, protected by
+// AdminMiddleware and allowed to outlive the server write timeout.
+func (ws *WebService) EnableLoadGen() *mux.Route {
+	handler := ws.DeadlineMiddleware(Deadlines{Read: DefaultTimeout, Write: maxLoadDuration + DefaultTimeout})(http.HandlerFunc(ws.LoadGenHandler))
+	return ws.Router.Handle("/debug/loadgen", ws.AdminMiddleware(handler)).Methods(http.MethodPost)
+}
@@ -0,0 +1,71 @@
+package fibre
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadGenHandler(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.AdminKey = "admin"
+	ws.EnableLoadGen()
+
+	body := `{"routes": ["/healthcheck"], "concurrency": 2, "duration": "50ms"}`
+	req := httptest.NewRequest("POST", "/debug/loadgen", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("LoadGenHandler returned wrong status code without admin_key: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("POST", "/debug/loadgen", strings.NewReader(body))
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LoadGenHandler returned wrong status code: got %v want %v: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var report LoadReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Statuses[http.StatusOK] != report.Requests || report.Errors != 0 {
+		t.Errorf("LoadGenHandler returned unexpected report: %+v", report)
+	}
+	if _, ok := report.Latency["p99"]; !ok {
+		t.Errorf("LoadGenHandler did not report latency percentiles: %+v", report)
+	}
+
+	req = httptest.NewRequest("POST", "/debug/loadgen", strings.NewReader(`{"routes": ["/debug/loadgen"], "concurrency": 1, "duration": "1s"}`))
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("LoadGenHandler accepted a recursive route: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGenerateLoadUncounted(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	report := ws.GenerateLoad(ctx, LoadRequest{Routes: []string{"/healthcheck"}, Concurrency: 2, Duration: "10s"})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GenerateLoad ran on after its context was cancelled: %v", elapsed)
+	}
+	if report.Requests == 0 {
+		t.Fatalf("GenerateLoad made no requests: %+v", report)
+	}
+	ws.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthcheck", nil))
+	if n := ws.Metrics.Get("route_requests GET /healthcheck"); n != 1 {
+		t.Errorf("GenerateLoad requests were counted in route metrics: got %v want %v", n, 1)
+	}
+}
@@ -0,0 +1,263 @@
+package fibre
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for LoginGuard fields left zero.
+const (
+	DefaultLoginMaxFailures   = 5
+	DefaultLoginIPMaxFailures = 20
+	DefaultLoginLockout       = time.Minute
+	DefaultLoginMaxLockout    = time.Hour
+	DefaultLoginWindow        = 15 * time.Minute
+)
+
+type loginAttempts struct {
+	failures    int
+	lockouts    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// struct LockoutEvent is passed to LoginGuard.OnLockout when an account or
+// client address is locked out.
+type LockoutEvent struct {
+	// Kind is "account" or "ip".
+	Kind     string
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+// struct LoginGuard protects logins from brute force attempts, counting
+// failures per account and per client address.  After MaxFailures failures
+// for an account (IPMaxFailures for an address) within Window, it is
+// locked out for Lockout, doubling with each further lockout up to
+// MaxLockout.  A successful login clears the account's record.  A nil
+// *LoginGuard locks nothing out.
+type LoginGuard struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+	Window        time.Duration
+
+	// OnLockout, when set, is called (without locks held) for each lockout,
+	// to notify account holders or operators.
+	OnLockout func(LockoutEvent)
+
+	mu       sync.Mutex
+	accounts map[string]*loginAttempts
+	ips      map[string]*loginAttempts
+}
+
+// NewLoginGuard returns a guard with the default limits.
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{}
+}
+
+func orDefault[T int | int64 | time.Duration](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// attempts returns the record for key, expiring stale failures.
+func (g *LoginGuard) attempts(m map[string]*loginAttempts, key string, now time.Time, create bool) *loginAttempts {
+	a, ok := m[key]
+	if !ok {
+		if !create {
+			return nil
+		}
+		if len(m) >= maxBuckets {
+			g.prune(m, now)
+		}
+		a = &loginAttempts{}
+		m[key] = a
+	}
+	window := orDefault(g.Window, DefaultLoginWindow)
+	if now.Sub(a.last) > window && now.After(a.lockedUntil) {
+		a.failures = 0
+		if now.Sub(a.lockedUntil) > window {
+			a.lockouts = 0
+		}
+	}
+	return a
+}
+
+// prune drops records which have expired or, when none have, the least
+// recently failed record not locked out (or the least recently failed of
+// all), so spraying unique keys cannot grow m past maxBuckets.
+func (g *LoginGuard) prune(m map[string]*loginAttempts, now time.Time) {
+	window := orDefault(g.Window, DefaultLoginWindow)
+	var oldest string
+	var oldestLocked bool
+	for k, a := range m {
+		if now.Sub(a.last) > window && now.Sub(a.lockedUntil) > window {
+			delete(m, k)
+			continue
+		}
+		locked := now.Before(a.lockedUntil)
+		if oldest == "" || (oldestLocked && !locked) || (oldestLocked == locked && a.last.Before(m[oldest].last)) {
+			oldest, oldestLocked = k, locked
+		}
+	}
+	if len(m) >= maxBuckets {
+		delete(m, oldest)
+	}
+}
+
+// Locked returns how long account, or the client address ip, remains
+// locked out; zero when a login may be attempted.
+func (g *LoginGuard) Locked(account, ip string) time.Duration {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, a := range []*loginAttempts{g.attempts(g.accounts, account, now, false), g.attempts(g.ips, ip, now, false)} {
+		if a != nil && a.lockedUntil.Sub(now) > wait {
+			wait = a.lockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail counts a failure against a, locking it out at max failures.
+func (g *LoginGuard) fail(a *loginAttempts, max int, now time.Time) bool {
+	a.failures++
+	a.last = now
+	if a.failures < max {
+		return false
+	}
+	lockout := orDefault(g.Lockout, DefaultLoginLockout)
+	maxLockout := orDefault(g.MaxLockout, DefaultLoginMaxLockout)
+	for i := 0; i < a.lockouts && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+	a.lockouts++
+	a.failures = 0
+	a.lockedUntil = now.Add(lockout)
+	return true
+}
+
+// Failure records a failed login for account from ip, returning the
+// lockouts it caused.
+func (g *LoginGuard) Failure(account, ip string) []LockoutEvent {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	if g.accounts == nil {
+		g.accounts = make(map[string]*loginAttempts)
+		g.ips = make(map[string]*loginAttempts)
+	}
+	now := time.Now()
+	var events []LockoutEvent
+	if a := g.attempts(g.accounts, account, now, true); g.fail(a, orDefault(g.MaxFailures, DefaultLoginMaxFailures), now) {
+		events = append(events, LockoutEvent{Kind: "account", Key: account, Failures: orDefault(g.MaxFailures, DefaultLoginMaxFailures), Until: a.lockedUntil})
+	}
+	if a := g.attempts(g.ips, ip, now, true); g.fail(a, orDefault(g.IPMaxFailures, DefaultLoginIPMaxFailures), now) {
+		events = append(events, LockoutEvent{Kind: "ip", Key: ip, Failures: orDefault(g.IPMaxFailures, DefaultLoginIPMaxFailures), Until: a.lockedUntil})
+	}
+	g.mu.Unlock()
+
+	if g.OnLockout != nil {
+		for _, e := range events {
+			g.OnLockout(e)
+		}
+	}
+	return events
+}
+
+// Success clears the failures and lockouts recorded for account.
+func (g *LoginGuard) Success(account string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	delete(g.accounts, account)
+	g.mu.Unlock()
+}
+
+// LoginAllowed reports whether a login for account may be attempted from
+// r's client, answering 429 with Retry-After and auditing the refusal when
+// it may not.
+func (ws *WebService) LoginAllowed(w http.ResponseWriter, r *http.Request, account string) bool {
+	wait := ws.Logins.Locked(account, clientIP(r))
+	if wait <= 0 {
+		return true
+	}
+	ws.Metrics.Inc("login_locked")
+	ws.audit(r, AuditEvent{Action: "login.locked", Actor: account})
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+	ws.JsonStatusResponse(w, "Too many failed logins", http.StatusTooManyRequests)
+	return false
+}
+
+// RecordLogin records the outcome of a login for account from r's client
+// in ws.Logins and ws.Audit, for handlers implementing their own (session
+// based) logins.  Check LoginAllowed before verifying credentials.
+func (ws *WebService) RecordLogin(r *http.Request, account string, success bool) {
+	ws.audit(r, AuditEvent{Action: "login", Actor: account, Success: success})
+	if success {
+		ws.Metrics.Inc("login_success")
+		ws.Logins.Success(account)
+		return
+	}
+	ws.Metrics.Inc("login_failure")
+	for _, e := range ws.Logins.Failure(account, clientIP(r)) {
+		ws.Metrics.Inc("login_lockouts")
+		ws.audit(r, AuditEvent{Action: "login.lockout", Actor: account, Target: e.Kind + ":" + e.Key, Detail: map[string]string{"until": e.Until.Format(time.RFC3339)}})
+	}
+}
+
+// BasicAuthMiddleware returns middleware requiring HTTP basic auth checked
+// by verify, protected by ws.Logins.  Failed logins are audited.
+func (ws *WebService) BasicAuthMiddleware(realm string, verify func(user, password string) bool) func(http.Handler) http.Handler {
+	challenge := `Basic realm="` + realm + `"`
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", challenge)
+				ws.JsonStatusResponse(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !ws.LoginAllowed(w, r, user) {
+				return
+			}
+			// every request carries the credentials, so only failures are
+			// audited.
+			if !verify(user, password) {
+				ws.RecordLogin(r, user, false)
+				w.Header().Set("WWW-Authenticate", challenge)
+				ws.JsonStatusResponse(w, "Invalid credentials", http.StatusUnauthorized)
+				return
+			}
+			ws.Logins.Success(user)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BasicAuthUsers returns a verify func for BasicAuthMiddleware checking
+// against a fixed map of users to passwords, in constant time.
+func BasicAuthUsers(users map[string]string) func(user, password string) bool {
+	return func(user, password string) bool {
+		want, ok := users[user]
+		match := subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+		return ok && match
+	}
+}
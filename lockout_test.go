@@ -0,0 +1,101 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	g := &LoginGuard{MaxFailures: 2, IPMaxFailures: 5, Lockout: time.Minute}
+	var events []LockoutEvent
+	g.OnLockout = func(e LockoutEvent) { events = append(events, e) }
+
+	g.Failure("alice", "10.0.0.1")
+	if wait := g.Locked("alice", "10.0.0.2"); wait != 0 {
+		t.Errorf("account locked after one failure: got %v", wait)
+	}
+	g.Failure("alice", "10.0.0.1")
+	if wait := g.Locked("alice", "10.0.0.2"); wait <= 0 || wait > time.Minute {
+		t.Errorf("account lockout has wrong duration: got %v want %v", wait, time.Minute)
+	}
+
+	// a second lockout doubles.
+	g.Failure("alice", "10.0.0.1")
+	g.Failure("alice", "10.0.0.1")
+	if wait := g.Locked("alice", "10.0.0.2"); wait <= time.Minute || wait > 2*time.Minute {
+		t.Errorf("second lockout has wrong duration: got %v want %v", wait, 2*time.Minute)
+	}
+
+	// five failures lock the address out, for every account.
+	g.Failure("bob", "10.0.0.1")
+	if wait := g.Locked("carol", "10.0.0.1"); wait <= 0 {
+		t.Errorf("address not locked after %v failures", 5)
+	}
+	if len(events) != 3 || events[2].Kind != "ip" || events[2].Key != "10.0.0.1" {
+		t.Errorf("OnLockout was called wrongly: got %+v", events)
+	}
+
+	g.Success("alice")
+	if wait := g.Locked("alice", "10.0.0.2"); wait != 0 {
+		t.Errorf("account still locked after success: got %v", wait)
+	}
+}
+
+func TestLoginGuardBounded(t *testing.T) {
+	g := &LoginGuard{MaxFailures: 1}
+	g.Failure("alice", "10.0.0.1")
+	for i := 0; i < maxBuckets+10; i++ {
+		g.Failure("user"+strconv.Itoa(i), "10.0.0.2")
+	}
+	if n := len(g.accounts); n > maxBuckets {
+		t.Errorf("LoginGuard tracked too many accounts: got %v want at most %v", n, maxBuckets)
+	}
+	if wait := g.Locked("user"+strconv.Itoa(maxBuckets+9), "10.0.0.3"); wait <= 0 {
+		t.Errorf("LoginGuard did not lock out the newest account")
+	}
+}
+
+func TestBasicAuthLockout(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Audit = NewAuditLog(nil)
+	ws.Logins.MaxFailures = 2
+	handler := ws.BasicAuthMiddleware("fibre", BasicAuthUsers(map[string]string{"alice": "secret"}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		password string
+		status   int
+	}{
+		{"secret", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("alice", tt.password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("login %d returned wrong status code: got %v want %v", i, status, tt.status)
+		}
+	}
+
+	var actions []string
+	for _, e := range ws.Audit.Recent() {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"login", "login", "login.lockout", "login.locked"}
+	if len(actions) != len(want) {
+		t.Fatalf("audit log recorded wrong events: got %v want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("audit log recorded wrong events: got %v want %v", actions, want)
+			break
+		}
+	}
+}
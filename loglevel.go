@@ -0,0 +1,72 @@
+package fibre
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LogLevel sets which of the instance's own log lines are written.  The
+// zero value is LogInfo.
+type LogLevel int32
+
+// Log levels, from most to least verbose.
+const (
+	LogDebug LogLevel = iota - 1
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+// ParseLogLevel returns the level named debug, info, warn or error.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for l, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("fibre: unknown log level %q", name)
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	parsed, err := ParseLogLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// LogLevel returns the instance's current log level.
+func (ws *WebService) LogLevel() LogLevel {
+	return LogLevel(ws.logLevel.Load())
+}
+
+// SetLogLevel changes the instance's log level; it is safe to call while
+// serving.  Request lines from LogMiddleware are info, cache warming
+// failures warn, and proxy errors error.
+func (ws *WebService) SetLogLevel(l LogLevel) {
+	ws.logLevel.Store(int32(l))
+}
+
+// logs reports whether lines at level l are written.
+func (ws *WebService) logs(l LogLevel) bool {
+	return l >= ws.LogLevel()
+}
@@ -0,0 +1,62 @@
+package fibre
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaintenanceMessage is answered in maintenance mode when
+// MaintenanceMode.Message is empty.
+const DefaultMaintenanceMessage = "Down for maintenance"
+
+// struct MaintenanceMode answers every request with 503 and Message while
+// Enabled, except health checks, the admin router, the request trace and
+// ACME challenges.
+// RetryAfter, when set, is sent as Retry-After, in seconds.
+type MaintenanceMode struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// Maintenance returns the current maintenance mode.
+func (ws *WebService) Maintenance() MaintenanceMode {
+	if m := ws.maintenance.Load(); m != nil {
+		return *m
+	}
+	return MaintenanceMode{}
+}
+
+// SetMaintenance changes the maintenance mode; it is safe to call while
+// serving.
+func (ws *WebService) SetMaintenance(m MaintenanceMode) {
+	ws.maintenance.Store(&m)
+}
+
+// maintenanceExempt reports whether path is served in maintenance mode.
+func maintenanceExempt(path string) bool {
+	return path == "/healthcheck" || path == requestTracePath || path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// maintenanceMiddleware answers requests with 503 while maintenance mode
+// is enabled.
+func (ws *WebService) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := ws.maintenance.Load()
+		if m == nil || !m.Enabled || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ws.Metrics.Inc("maintenance_refused")
+		if m.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		message := m.Message
+		if message == "" {
+			message = DefaultMaintenanceMessage
+		}
+		ws.JsonStatusResponse(w, message, http.StatusServiceUnavailable)
+	})
+}
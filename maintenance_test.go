@@ -0,0 +1,46 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	ws.Admin().HandleFunc("/config", ws.ConfigHandler)
+	ws.AdminKey = "admin"
+	ws.EnableRequestTrace(0)
+	handler := ws.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	ws.SetMaintenance(MaintenanceMode{Enabled: true, RetryAfter: 120})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if status := w.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code in maintenance: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("handler returned wrong Retry-After: got %q want %q", got, "120")
+	}
+
+	for _, path := range []string{"/healthcheck", "/admin/config", "/debug/requests"} {
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("admin_key", "admin")
+		handler.ServeHTTP(w, req)
+		if w.Code == http.StatusServiceUnavailable {
+			t.Errorf("handler refused %s in maintenance", path)
+		}
+	}
+
+	if state := ws.Status().State; state != StateMaintenance {
+		t.Errorf("Status returned wrong state: got %v want %v", state, StateMaintenance)
+	}
+}
@@ -0,0 +1,102 @@
+package fibre
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// struct MethodPolicy declares which HTTP methods the instance accepts.
+// Deny lists methods refused everywhere (eg; TRACE, TRACK); Groups maps a
+// path prefix to the only methods allowed beneath it, the longest matching
+// prefix winning.  Prefixes match whole path segments, so /api covers
+// /api/items but not /apiary.  Allowing GET also allows HEAD.
+type MethodPolicy struct {
+	Deny   []string
+	Groups map[string][]string
+}
+
+// underPrefix reports whether path is prefix or beneath it.
+func underPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// allowed returns whether method may be used on path, and the methods
+// allowed there.
+func (mp *MethodPolicy) allowed(method, path string) (bool, []string) {
+	prefix := ""
+	for p := range mp.Groups {
+		if underPrefix(path, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+
+	var allow []string
+	if prefix == "" {
+		for m := range standardMethods {
+			allow = append(allow, m)
+		}
+	} else {
+		allow = append(allow, mp.Groups[prefix]...)
+		if slices.Contains(allow, http.MethodGet) && !slices.Contains(allow, http.MethodHead) {
+			allow = append(allow, http.MethodHead)
+		}
+	}
+	allow = slices.DeleteFunc(allow, func(m string) bool {
+		return slices.ContainsFunc(mp.Deny, func(d string) bool { return strings.EqualFold(d, m) })
+	})
+	sort.Strings(allow)
+
+	if slices.ContainsFunc(mp.Deny, func(d string) bool { return strings.EqualFold(d, method) }) {
+		return false, allow
+	}
+	if prefix == "" {
+		return true, allow
+	}
+	return slices.Contains(allow, method), allow
+}
+
+// MethodMiddleware enforces ws.MethodPolicy, answering unrecognised methods
+// with 501 Not Implemented and refused ones with 405 Method Not Allowed.
+func (ws *WebService) MethodMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws.MethodPolicy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, allow := ws.MethodPolicy.allowed(r.Method, r.URL.Path)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ws.Metrics.Inc("method_denied")
+		if !standardMethods[r.Method] {
+			if ws.StrictJSON {
+				ws.jsonError(w, "method not implemented", http.StatusNotImplemented)
+				return
+			}
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		if len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+		}
+		ws.MethodNotAllowedHandler(w, r)
+	})
+}
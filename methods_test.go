@@ -0,0 +1,45 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodPolicy(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.Router.HandleFunc("/api/items", ws.HealthCheckHandler)
+	ws.Router.HandleFunc("/apiary", ws.HealthCheckHandler)
+	ws.Router.HandleFunc("/docs", ws.HealthCheckHandler)
+	ws.MethodPolicy = &MethodPolicy{
+		Deny:   []string{"TRACE", "TRACK"},
+		Groups: map[string][]string{"/api/": {"GET", "POST"}, "/api": {"GET"}, "/docs": {"GET", "TRACE"}},
+	}
+	handler := ws.Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"GET", "/healthcheck", http.StatusOK, ""},
+		{"TRACE", "/healthcheck", http.StatusMethodNotAllowed, "CONNECT, DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT"},
+		{"TRACE", "/docs", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"POST", "/apiary", http.StatusOK, ""},
+		{"TRACK", "/healthcheck", http.StatusNotImplemented, ""},
+		{"HEAD", "/api/items", http.StatusOK, ""},
+		{"DELETE", "/api/items", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("MethodMiddleware returned wrong status code for %v %v: got %v want %v", tt.method, tt.path, w.Code, tt.status)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("MethodMiddleware returned unexpected Allow header for %v %v: got %v want %v", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package fibre
+
+import (
+	"net/http"
+	"sync"
+)
+
+// struct Metrics holds simple named counters for an instance.  A nil *Metrics
+// is valid and silently discards updates.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// NewMetrics returns an empty set of counters.
+func NewMetrics() *Metrics {
+	return &Metrics{counters: make(map[string]int64)}
+}
+
+// Add increments the named counter by n.
+func (m *Metrics) Add(name string, n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.counters[name] += n
+	m.mu.Unlock()
+}
+
+// Inc increments the named counter by one.
+func (m *Metrics) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Get returns the current value of the named counter.
+func (m *Metrics) Get(name string) int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+// Snapshot returns a copy of all counters.
+func (m *Metrics) Snapshot() map[string]int64 {
+	out := make(map[string]int64)
+	if m == nil {
+		return out
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.counters {
+		out[k] = v
+	}
+	return out
+}
+
+// MetricsHandler writes the instance counters as JSON.
+func (ws *WebService) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ws.writeJSON(w, ws.Metrics.Snapshot(), http.StatusOK)
+}
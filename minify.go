@@ -0,0 +1,345 @@
+package fibre
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// struct MinifyConfig selects which response types are minified.  Source map
+// references are stripped unless PreserveSourceMaps is set.
+type MinifyConfig struct {
+	HTML bool
+	CSS  bool
+	JS   bool
+
+	PreserveSourceMaps bool
+}
+
+var rawTags = []string{"pre", "textarea", "script", "style"}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// rawTag returns the name of the whitespace sensitive element opened at the
+// start of b, if any.
+func rawTag(b []byte) string {
+	for _, tag := range rawTags {
+		n := len(tag) + 1
+		if len(b) > n && bytes.EqualFold(b[1:n], []byte(tag)) {
+			if c := b[n]; c == '>' || c == '/' || isSpace(c) {
+				return tag
+			}
+		}
+	}
+	return ""
+}
+
+// indexCloseTag returns the index in b of the first "</tag", in any case,
+// or -1.
+func indexCloseTag(b []byte, tag string) int {
+	for i := 0; ; {
+		j := bytes.Index(b[i:], []byte("</"))
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if len(b)-i-2 >= len(tag) && bytes.EqualFold(b[i+2:i+2+len(tag)], []byte(tag)) {
+			return i
+		}
+		i += 2
+	}
+}
+
+// isTagStart reports whether b, following a '<', starts a tag rather than
+// text.
+func isTagStart(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	c := b[0]
+	return c == '/' || c == '!' || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+// MinifyHTML removes comments (other than conditional comments) and collapses
+// whitespace, leaving quoted attribute values and pre, textarea, script and
+// style contents untouched.
+func MinifyHTML(src []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(src))
+	space, inTag := false, false
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c == '<' && bytes.HasPrefix(src[i:], []byte("<!--")) && !bytes.HasPrefix(src[i:], []byte("<!--[if")) {
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				// an unterminated comment is left as written.
+				if space && out.Len() > 0 {
+					out.WriteByte(' ')
+				}
+				out.Write(src[i:])
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		if isSpace(c) {
+			space = true
+			i++
+			continue
+		}
+		if space && out.Len() > 0 {
+			out.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '<':
+			if tag := rawTag(src[i:]); tag != "" {
+				end := indexCloseTag(src[i+1:], tag)
+				if end < 0 {
+					end = len(src) - i - 1
+				}
+				out.Write(src[i : i+1+end])
+				i += 1 + end
+				continue
+			}
+			inTag = isTagStart(src[i+1:])
+		case c == '>':
+			inTag = false
+		case inTag && (c == '"' || c == '\''):
+			end := bytes.IndexByte(src[i+1:], c)
+			if end < 0 {
+				end = len(src) - i - 1
+			} else {
+				end++
+			}
+			out.Write(src[i : i+1+end])
+			i += 1 + end
+			continue
+		}
+		out.WriteByte(c)
+		i++
+	}
+	return out.Bytes()
+}
+
+// MinifyCSS removes comments and collapses whitespace outside of strings.
+func MinifyCSS(src []byte, keepSourceMaps bool) []byte {
+	var out bytes.Buffer
+	out.Grow(len(src))
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src) - i - 2
+			} else {
+				end += 2
+			}
+			comment := src[i : i+2+end]
+			if keepSourceMaps && bytes.HasPrefix(comment, []byte("/*# sourceMappingURL=")) {
+				out.Write(comment)
+			}
+			i += 2 + end
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(src) {
+				j++
+			}
+			if space && out.Len() > 0 {
+				out.WriteByte(' ')
+			}
+			space = false
+			out.Write(src[i:j])
+			i = j
+		case isSpace(c):
+			space = true
+			i++
+		case bytes.IndexByte([]byte("{};,>"), c) >= 0:
+			space = false
+			if c == '}' && bytes.HasSuffix(out.Bytes(), []byte(";")) {
+				out.Truncate(out.Len() - 1)
+			}
+			out.WriteByte(c)
+			i++
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+		default:
+			last := out.Bytes()
+			if space && len(last) > 0 && bytes.IndexByte([]byte("{};,>"), last[len(last)-1]) < 0 {
+				out.WriteByte(' ')
+			}
+			space = false
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
+
+// MinifyJS trims indentation, blank lines and whole-line comments.  It is
+// deliberately conservative: lines continuing a string after a trailing
+// backslash are kept as written, and sources containing template literals
+// are left as is apart from source map removal.
+func MinifyJS(src []byte, keepSourceMaps bool) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	literal := bytes.IndexByte(src, '`') >= 0
+	var out bytes.Buffer
+	out.Grow(len(src))
+	comment, continued := false, false
+	for _, line := range lines {
+		if continued {
+			continued = bytes.HasSuffix(bytes.TrimRight(line, "\r"), []byte("\\"))
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		trimmed := bytes.TrimSpace(line)
+		if bytes.HasPrefix(trimmed, []byte("//# sourceMappingURL=")) {
+			if keepSourceMaps {
+				out.Write(trimmed)
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		if literal {
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		if comment {
+			if bytes.Contains(trimmed, []byte("*/")) {
+				comment = false
+				if rest := bytes.TrimSpace(trimmed[bytes.Index(trimmed, []byte("*/"))+2:]); len(rest) > 0 {
+					out.Write(rest)
+					out.WriteByte('\n')
+				}
+			}
+			continue
+		}
+		if len(trimmed) == 0 || bytes.HasPrefix(trimmed, []byte("//")) {
+			continue
+		}
+		if bytes.HasPrefix(trimmed, []byte("/*")) && !bytes.Contains(trimmed[2:], []byte("*/")) {
+			comment = true
+			continue
+		}
+		out.Write(trimmed)
+		out.WriteByte('\n')
+		continued = bytes.HasSuffix(trimmed, []byte("\\"))
+	}
+	if !bytes.HasSuffix(src, []byte("\n")) && out.Len() > 0 {
+		out.Truncate(out.Len() - 1)
+	}
+	return out.Bytes()
+}
+
+// minify applies the configured minifier for contentType to b.
+func (mc *MinifyConfig) minify(contentType string, b []byte) ([]byte, bool) {
+	if mc == nil {
+		return b, false
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == "text/html" && mc.HTML:
+		return MinifyHTML(b), true
+	case mt == "text/css" && mc.CSS:
+		return MinifyCSS(b, mc.PreserveSourceMaps), true
+	case (mt == "application/javascript" || mt == "text/javascript") && mc.JS:
+		return MinifyJS(b, mc.PreserveSourceMaps), true
+	}
+	return b, false
+}
+
+// minifyWriter buffers minifiable responses and passes anything else
+// straight through.
+type minifyWriter struct {
+	http.ResponseWriter
+	config    *MinifyConfig
+	status    int
+	buffering bool
+	decided   bool
+	buf       bytes.Buffer
+}
+
+func (mw *minifyWriter) WriteHeader(status int) {
+	if mw.decided {
+		return
+	}
+	mw.decided = true
+	mw.status = status
+	h := mw.Header()
+	// ranges index the unminified body, and 204 and 304 have none.
+	partial := status == http.StatusPartialContent || status == http.StatusNoContent || status == http.StatusNotModified
+	if partial || h.Get("Content-Type") == "" || h.Get("Content-Encoding") != "" {
+		mw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if _, ok := mw.config.minify(h.Get("Content-Type"), nil); !ok {
+		mw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	mw.buffering = true
+}
+
+func (mw *minifyWriter) Write(p []byte) (int, error) {
+	if !mw.decided {
+		if mw.Header().Get("Content-Type") == "" {
+			mw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		mw.WriteHeader(http.StatusOK)
+	}
+	if mw.buffering {
+		return mw.buf.Write(p)
+	}
+	return mw.ResponseWriter.Write(p)
+}
+
+func (mw *minifyWriter) Flush() {
+	if !mw.buffering {
+		http.NewResponseController(mw.ResponseWriter).Flush()
+	}
+}
+
+func (mw *minifyWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+func (mw *minifyWriter) finish() {
+	if !mw.buffering {
+		return
+	}
+	body, _ := mw.config.minify(mw.Header().Get("Content-Type"), mw.buf.Bytes())
+	if !mw.config.PreserveSourceMaps {
+		mw.Header().Del("SourceMap")
+		mw.Header().Del("X-SourceMap")
+	}
+	mw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	mw.ResponseWriter.WriteHeader(mw.status)
+	mw.ResponseWriter.Write(body)
+}
+
+// MinifyMiddleware minifies HTML, CSS and JS responses on the fly according
+// to ws.Minify.  Responses to HEAD and range requests are left alone.
+func (ws *WebService) MinifyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws.Minify == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mw := &minifyWriter{ResponseWriter: w, config: ws.Minify}
+		next.ServeHTTP(mw, r)
+		mw.finish()
+	})
+}
@@ -0,0 +1,124 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	src := "<html>\n  <!-- note -->\n  <body>\n    <p>a   b</p>\n    <pre>  x\n  y</pre>\n  </body>\n</html>\n"
+	expected := "<html> <body> <p>a b</p> <pre>  x\n  y</pre> </body> </html>"
+
+	if got := string(MinifyHTML([]byte(src))); got != expected {
+		t.Errorf("MinifyHTML returned unexpected output: got %q want %q", got, expected)
+	}
+}
+
+func TestMinifyHTMLUnterminated(t *testing.T) {
+	tests := []struct {
+		src, expected string
+	}{
+		{"<p>a</p>  <!-- open <b>rest</b>", "<p>a</p> <!-- open <b>rest</b>"},
+		{"<SCRIPT>  x  </Script>  <p>", "<SCRIPT>  x  </Script> <p>"},
+		{"<style>  a  </sty", "<style>  a  </sty"},
+	}
+	for _, tt := range tests {
+		if got := string(MinifyHTML([]byte(tt.src))); got != tt.expected {
+			t.Errorf("MinifyHTML returned unexpected output: got %q want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestMinifyHTMLAttributes(t *testing.T) {
+	src := "<input  title=\"a   b\"\n  value='x\n y'>  <p>1 < 2  don't</p>"
+	expected := "<input title=\"a   b\" value='x\n y'> <p>1 < 2 don't</p>"
+
+	if got := string(MinifyHTML([]byte(src))); got != expected {
+		t.Errorf("MinifyHTML returned unexpected output: got %q want %q", got, expected)
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	src := "/* header */\nbody {\n  color: red;\n  font-family: \"a  b\";\n}\n/*# sourceMappingURL=site.css.map */\n"
+	expected := "body{color: red;font-family: \"a  b\"}"
+
+	if got := string(MinifyCSS([]byte(src), false)); got != expected {
+		t.Errorf("MinifyCSS returned unexpected output: got %q want %q", got, expected)
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	src := "// header\nfunction f() {\n\n    return 1;\n}\n//# sourceMappingURL=app.js.map\n"
+
+	if got := string(MinifyJS([]byte(src), false)); got != "function f() {\nreturn 1;\n}\n" {
+		t.Errorf("MinifyJS returned unexpected output: got %q", got)
+	}
+
+	if got := string(MinifyJS([]byte(src), true)); got != "function f() {\nreturn 1;\n}\n//# sourceMappingURL=app.js.map\n" {
+		t.Errorf("MinifyJS did not preserve source map: got %q", got)
+	}
+}
+
+func TestMinifyJSContinuedString(t *testing.T) {
+	src := "var s = \"a \\\n    b \\\n  c\";\n    f();\n"
+	expected := "var s = \"a \\\n    b \\\n  c\";\nf();\n"
+
+	if got := string(MinifyJS([]byte(src), false)); got != expected {
+		t.Errorf("MinifyJS returned unexpected output: got %q want %q", got, expected)
+	}
+}
+
+func TestMinifyMiddleware(t *testing.T) {
+	ws := new(WebService)
+	ws.Minify = &MinifyConfig{CSS: true}
+
+	handler := ws.MinifyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		io.WriteString(w, "a {\n  color: red;\n}\n")
+	}))
+
+	req, err := http.NewRequest("GET", "/static/site.css", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Body.String() != "a{color: red}" {
+		t.Errorf("MinifyMiddleware returned unexpected body: got %q want %q", w.Body.String(), "a{color: red}")
+	}
+
+	if w.Header().Get("Content-Length") != "13" {
+		t.Errorf("MinifyMiddleware returned unexpected Content-Length: got %v want %v", w.Header().Get("Content-Length"), 13)
+	}
+}
+
+func TestMinifyMiddlewareSkips(t *testing.T) {
+	ws := new(WebService)
+	ws.Minify = &MinifyConfig{CSS: true}
+	css := "a {\n  color: red;\n}\n"
+	handler := ws.MinifyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		io.WriteString(w, css)
+	}))
+
+	req := httptest.NewRequest("GET", "/static/site.css", nil)
+	req.Header.Set("Range", "bytes=0-")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != css {
+		t.Errorf("MinifyMiddleware minified a range response: got %v %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/static/site.css", nil))
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("MinifyMiddleware set Content-Length for HEAD: got %v", w.Header().Get("Content-Length"))
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package fibre
+
+const raceEnabled = false
@@ -0,0 +1,100 @@
+package fibre
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var pathVarPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// struct OpenAPIInfo describes the API in the generated specification.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPI builds an OpenAPI 3 specification from the routes registered on
+// ws.Router, leaving out the admin router.  Routes without methods are
+// documented as GET.
+func (ws *WebService) OpenAPI(info OpenAPIInfo) map[string]interface{} {
+	if info.Title == "" {
+		info.Title = ws.Instance
+	}
+	if info.Version == "" {
+		info.Version = "1.0.0"
+	}
+
+	paths := make(map[string]map[string]interface{})
+	ws.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil || strings.HasPrefix(tmpl, "/admin/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		var params []interface{}
+		for _, m := range pathVarPattern.FindAllStringSubmatch(tmpl, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+		path := pathVarPattern.ReplaceAllString(tmpl, "{$1}")
+
+		item, ok := paths[path]
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		for _, method := range methods {
+			op := map[string]interface{}{
+				"responses": map[string]interface{}{
+					"default": map[string]string{"description": "response"},
+				},
+			}
+			if len(params) > 0 {
+				op["parameters"] = params
+			}
+			if doc := ws.routeDoc(route); doc != nil {
+				if doc.Summary != "" {
+					op["summary"] = doc.Summary
+				}
+				if doc.Description != "" {
+					op["description"] = doc.Description
+				}
+				if len(doc.Tags) > 0 {
+					op["tags"] = doc.Tags
+				}
+				if doc.Deprecated {
+					op["deprecated"] = true
+				}
+			}
+			item[strings.ToLower(method)] = op
+		}
+		return nil
+	})
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+	}
+	if ws.Keys != nil || ws.Apikey != "" {
+		spec["components"] = map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"api_key": map[string]string{"type": "apiKey", "in": "header", "name": "api_key"},
+			},
+		}
+		spec["security"] = []map[string][]string{{"api_key": {}}}
+	}
+	return spec
+}
@@ -0,0 +1,37 @@
+package fibre
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// errPageNotAllowed is returned for page names outside of the allowlist.
+var errPageNotAllowed = errors.New("fibre: page not allowed")
+
+// pageAllowed reports whether PageHandler may render page, which must be
+// listed in ws.PageAllowlist when it is set.
+func (ws *WebService) pageAllowed(page string) bool {
+	if len(ws.PageAllowlist) == 0 {
+		return true
+	}
+	for _, p := range ws.PageAllowlist {
+		if p == page {
+			return true
+		}
+	}
+	return false
+}
+
+// pageLocation returns the template file for page, which must be a clean
+// name under web/<instance>/page.
+func (ws *WebService) pageLocation(page string) (string, error) {
+	if page == "" || strings.HasPrefix(page, ".") || strings.Contains(page, "/.") {
+		return "", errPageNotAllowed
+	}
+	location, err := containedPath("web/"+ws.Instance+"/page", page+".html")
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(location), nil
+}
@@ -0,0 +1,57 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPageLocation(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+
+	for _, bad := range []string{"", "../templates/base", "/etc/passwd", "a\x00b", "..\\x", ".hidden", "a/../../b"} {
+		if _, err := ws.pageLocation(bad); err == nil {
+			t.Errorf("pageLocation accepted %q", bad)
+		}
+	}
+
+	location, err := ws.pageLocation("index")
+	if err != nil || location != "web/test/page/index.html" {
+		t.Errorf("pageLocation returned unexpected location: got %v, %v want %v", location, err, "web/test/page/index.html")
+	}
+}
+
+func TestPageHandlerAllowlist(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+	ws.PageAllowlist = []string{"about"}
+
+	req, err := http.NewRequest("GET", "/page/index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"page": "index"})
+
+	w := httptest.NewRecorder()
+	ws.PageHandler(w, req)
+
+	if status := w.Code; status != http.StatusNotFound {
+		t.Errorf("PageHandler rendered a page outside of the allowlist: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestHomeHandlerAllowlist(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+	ws.PageAllowlist = []string{"about"}
+
+	w := httptest.NewRecorder()
+	ws.HomeHandler(w, httptest.NewRequest("GET", "/", nil))
+
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("HomeHandler returned wrong status code with an allowlist: got %v want %v", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,185 @@
+package fibre
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// struct PageKey identifies one rendering of a page.
+type PageKey struct {
+	Page   string
+	Locale string
+	Theme  string
+}
+
+// DefaultPageCacheMaxEntries bounds a PageCache with no MaxEntries.
+const DefaultPageCacheMaxEntries = 1000
+
+type cachedPage struct {
+	body       []byte
+	surrogates []string
+	stored     time.Time
+}
+
+// struct PageCache holds rendered pages in memory, tagged with surrogate keys
+// (the template files used to render them, relative to the instance's web
+// directory, such as templates/base.html) so every page built from a given
+// partial can be purged at once.  Past MaxEntries pages the oldest is
+// evicted.  A nil *PageCache caches nothing.
+type PageCache struct {
+	MaxEntries int
+
+	mu    sync.RWMutex
+	pages map[PageKey]*cachedPage
+}
+
+// NewPageCache returns an empty page cache.
+func NewPageCache() *PageCache {
+	return &PageCache{pages: make(map[PageKey]*cachedPage)}
+}
+
+// Get returns the cached body and surrogate keys for key.
+func (c *PageCache) Get(key PageKey) ([]byte, []string, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.pages[key]
+	if !ok {
+		return nil, nil, false
+	}
+	return p.body, p.surrogates, true
+}
+
+// Set stores body for key, tagged with the given surrogate keys.
+func (c *PageCache) Set(key PageKey, body []byte, surrogates []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pages[key]; !ok && len(c.pages) >= orDefault(c.MaxEntries, DefaultPageCacheMaxEntries) {
+		c.evict()
+	}
+	c.pages[key] = &cachedPage{
+		body:       append([]byte(nil), body...),
+		surrogates: append([]string(nil), surrogates...),
+		stored:     time.Now(),
+	}
+}
+
+// evict drops the oldest page.
+func (c *PageCache) evict() {
+	var oldest PageKey
+	var stored time.Time
+	for k, p := range c.pages {
+		if stored.IsZero() || p.stored.Before(stored) {
+			oldest, stored = k, p.stored
+		}
+	}
+	delete(c.pages, oldest)
+}
+
+// Purge removes every page tagged with surrogate and returns the number of
+// pages removed.
+func (c *PageCache) Purge(surrogate string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, p := range c.pages {
+		for _, s := range p.surrogates {
+			if s == surrogate {
+				delete(c.pages, k)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// PurgeAll empties the cache.
+func (c *PageCache) PurgeAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.pages = make(map[PageKey]*cachedPage)
+	c.mu.Unlock()
+}
+
+func (ws *WebService) pageKey(r *http.Request, page string) PageKey {
+	key := PageKey{Page: page}
+	if ws.PageVariant != nil {
+		key.Locale, key.Theme = ws.PageVariant(r)
+	}
+	return key
+}
+
+// surrogateKey returns the surrogate key for a template file, its path
+// within the instance's web directory.
+func (ws *WebService) surrogateKey(file string) string {
+	return strings.TrimPrefix(file, "web/"+ws.Instance+"/")
+}
+
+// templateFiles returns the modification times of every page and template
+// file for the instance.
+func (ws *WebService) templateFiles() map[string]time.Time {
+	files := make(map[string]time.Time)
+	for _, dir := range []string{"page", "templates"} {
+		matches, _ := filepath.Glob("web/" + ws.Instance + "/" + dir + "/*.html")
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil {
+				files[filepath.ToSlash(m)] = fi.ModTime()
+			}
+		}
+	}
+	return files
+}
+
+// ReloadTemplates compares template files against previous, purging pages
+// which used any file that changed or was removed and rerunning any enabled
+// preflight, and returns the current modification times for the next call.
+func (ws *WebService) ReloadTemplates(previous map[string]time.Time) map[string]time.Time {
+	current := ws.templateFiles()
+	changed := len(current) != len(previous)
+	for f, mt := range previous {
+		if cmt, ok := current[f]; !ok || !cmt.Equal(mt) {
+			ws.PageCache.Purge(ws.surrogateKey(f))
+			changed = true
+		}
+	}
+	if changed {
+		ws.refreshPreflight()
+	}
+	return current
+}
+
+// WatchTemplates polls the instance templates every interval and purges
+// cached pages built from changed files.  Call the returned func to stop.
+func (ws *WebService) WatchTemplates(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	files := ws.templateFiles()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				files = ws.ReloadTemplates(files)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,89 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPageCachePurge(t *testing.T) {
+	c := NewPageCache()
+	c.Set(PageKey{Page: "index"}, []byte("index"), []string{"page/index.html", "templates/base.html"})
+	c.Set(PageKey{Page: "about"}, []byte("about"), []string{"page/about.html", "templates/base.html"})
+
+	if n := c.Purge("page/about.html"); n != 1 {
+		t.Errorf("PageCache.Purge removed wrong number of pages: got %v want %v", n, 1)
+	}
+
+	if _, _, ok := c.Get(PageKey{Page: "index"}); !ok {
+		t.Errorf("PageCache.Purge removed a page without the surrogate key")
+	}
+
+	if n := c.Purge("templates/base.html"); n != 1 {
+		t.Errorf("PageCache.Purge removed wrong number of pages: got %v want %v", n, 1)
+	}
+}
+
+func TestPageCacheMaxEntries(t *testing.T) {
+	c := &PageCache{MaxEntries: 2, pages: make(map[PageKey]*cachedPage)}
+	for _, page := range []string{"a", "b", "c"} {
+		c.Set(PageKey{Page: page}, []byte(page), nil)
+	}
+	if _, _, ok := c.Get(PageKey{Page: "a"}); ok || len(c.pages) != 2 {
+		t.Errorf("PageCache.Set did not evict the oldest page: got %v pages", len(c.pages))
+	}
+	if _, _, ok := c.Get(PageKey{Page: "c"}); !ok {
+		t.Errorf("PageCache.Set did not keep the newest page")
+	}
+}
+
+func TestHomeHandlerPageCache(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+	ws.PageCache = NewPageCache()
+	ws.PageVariant = func(r *http.Request) (string, string) {
+		return r.Header.Get("Accept-Language"), ""
+	}
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "en")
+
+	w := httptest.NewRecorder()
+	ws.HomeHandler(w, req)
+
+	body, surrogates, ok := ws.PageCache.Get(PageKey{Page: "index", Locale: "en"})
+	if !ok {
+		t.Fatalf("HomeHandler did not fill the page cache")
+	}
+
+	if string(body) != w.Body.String() {
+		t.Errorf("HomeHandler cached unexpected body: got %v want %v", string(body), w.Body.String())
+	}
+
+	if len(surrogates) != 2 || surrogates[1] != "templates/base.html" {
+		t.Errorf("HomeHandler cached unexpected surrogate keys: %v", surrogates)
+	}
+
+	if w.Header().Get("Surrogate-Key") == "" {
+		t.Errorf("HomeHandler did not set the Surrogate-Key header")
+	}
+}
+
+func TestReloadTemplatesPurgesChanged(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+	ws.PageCache = NewPageCache()
+	ws.PageCache.Set(PageKey{Page: "index"}, []byte("index"), []string{"page/index.html", "templates/base.html"})
+
+	files := ws.templateFiles()
+	files["web/test/templates/base.html"] = time.Time{}
+	ws.ReloadTemplates(files)
+
+	if _, _, ok := ws.PageCache.Get(PageKey{Page: "index"}); ok {
+		t.Errorf("ReloadTemplates did not purge a page using a changed template")
+	}
+}
@@ -0,0 +1,118 @@
+package fibre
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// struct DocsConfig configures the developer portal.  UI is "redoc" (the
+// default) or "swagger".
+type DocsConfig struct {
+	Info OpenAPIInfo
+	UI   string
+}
+
+var portalTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}} API</title>
+  {{if eq .UI "swagger"}}<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">{{end}}
+</head>
+<body>
+  <section>
+    <h1>{{.Title}} API</h1>
+    {{if .Auth}}
+    <h2>Authentication</h2>
+    <p>Send your key in the <code>api_key</code> header with every request:</p>
+    <pre>curl -H "api_key: YOUR_KEY" {{.Example}}</pre>
+    <h2>Your key</h2>
+    {{with .Error}}<p><strong>{{.}}</strong></p>{{end}}
+    {{with .Key}}
+    <p>Holder: {{.Name}}<br>Tier: {{.Tier}}</p>
+    {{end}}
+    {{with .NewKey}}
+    <p>Your new key is <code>{{.}}</code>.  The previous key no longer works.</p>
+    {{end}}
+    <form method="post" action="/docs/keys">
+      <input type="password" name="api_key" placeholder="api key" required>
+      <button name="action" value="view">View</button>
+      <button name="action" value="rotate">Rotate</button>
+    </form>
+    {{end}}
+  </section>
+  {{if eq .UI "swagger"}}
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "{{.SpecURL}}", dom_id: "#swagger-ui"});</script>
+  {{else}}
+  <redoc spec-url="{{.SpecURL}}"></redoc>
+  <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+  {{end}}
+</body>
+</html>
+`))
+
+type portalPage struct {
+	Title   string
+	UI      string
+	SpecURL string
+	Example string
+	Auth    bool
+	Key     *APIKey
+	NewKey  string
+	Error   string
+}
+
+func (ws *WebService) renderPortal(w http.ResponseWriter, r *http.Request, cfg DocsConfig, page portalPage, status int) {
+	page.Title = cfg.Info.Title
+	if page.Title == "" {
+		page.Title = ws.Instance
+	}
+	page.UI = cfg.UI
+	page.SpecURL = "/docs/openapi.json"
+	page.Example = "http://" + r.Host + "/"
+	page.Auth = ws.Keys != nil
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	portalTemplate.Execute(w, page)
+}
+
+// DeveloperPortal registers /docs, rendering the generated OpenAPI
+// specification (also served at /docs/openapi.json) with authentication
+// instructions, and /docs/keys where key holders can view or rotate their
+// key.
+func (ws *WebService) DeveloperPortal(cfg DocsConfig) {
+	ws.Router.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		ws.renderPortal(w, r, cfg, portalPage{}, http.StatusOK)
+	}).Methods(http.MethodGet)
+
+	ws.Router.HandleFunc("/docs/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		ws.writeJSON(w, ws.OpenAPI(cfg.Info), http.StatusOK)
+	}).Methods(http.MethodGet)
+
+	ws.Router.HandleFunc("/docs/keys", func(w http.ResponseWriter, r *http.Request) {
+		apik := r.PostFormValue("api_key")
+		var key APIKey
+		ok := false
+		if ws.Keys != nil && apik != "" {
+			key, ok = ws.Keys.Lookup(apik)
+		}
+		if !ok {
+			ws.renderPortal(w, r, cfg, portalPage{Error: "Invalid api_key"}, http.StatusUnauthorized)
+			return
+		}
+
+		page := portalPage{Key: &key}
+		if r.PostFormValue("action") == "rotate" {
+			rotated, err := ws.Keys.Rotate(apik)
+			if err != nil {
+				ws.renderPortal(w, r, cfg, portalPage{Error: "Key rotation failed"}, http.StatusInternalServerError)
+				return
+			}
+			page.NewKey = rotated.Key
+		}
+		ws.renderPortal(w, r, cfg, page, http.StatusOK)
+	}).Methods(http.MethodPost)
+}
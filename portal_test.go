@@ -0,0 +1,69 @@
+package fibre
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPI(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.Router.HandleFunc("/items/{id:[0-9]+}", ws.HealthCheckHandler).Methods("GET", "DELETE")
+
+	spec := ws.OpenAPI(OpenAPIInfo{})
+	paths := spec["paths"].(map[string]map[string]interface{})
+
+	item, ok := paths["/items/{id}"]
+	if !ok {
+		t.Fatalf("OpenAPI did not document /items/{id}: %v", paths)
+	}
+	if _, ok := item["delete"]; !ok {
+		t.Errorf("OpenAPI did not document the DELETE method: %v", item)
+	}
+	if _, ok := paths["/healthcheck"]; !ok {
+		t.Errorf("OpenAPI did not document /healthcheck: %v", paths)
+	}
+}
+
+func TestDeveloperPortalRotate(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	ws.Keys = NewKeyStore()
+	ws.Keys.AddKey(APIKey{Key: "oldkey", Name: "alice"})
+	ws.DeveloperPortal(DocsConfig{Info: OpenAPIInfo{Title: "Example"}})
+
+	w := httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/docs/openapi.json") {
+		t.Errorf("DeveloperPortal returned unexpected page: %v %v", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, httptest.NewRequest("GET", "/docs/openapi.json", nil))
+	var spec map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil || spec["openapi"] != "3.0.3" {
+		t.Errorf("DeveloperPortal returned unexpected specification: %v", w.Body.String())
+	}
+
+	form := url.Values{"api_key": {"oldkey"}, "action": {"rotate"}}
+	req := httptest.NewRequest("POST", "/docs/keys", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ws.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeveloperPortal returned wrong status code rotating a key: got %v want %v", w.Code, http.StatusOK)
+	}
+	if _, ok := ws.Keys.Lookup("oldkey"); ok {
+		t.Errorf("DeveloperPortal did not revoke the rotated key")
+	}
+	usage := ws.Keys.Usage()
+	if len(usage) != 1 || usage[0].Name != "alice" {
+		t.Errorf("DeveloperPortal lost the key holder on rotation: %+v", usage)
+	}
+}
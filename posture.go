@@ -0,0 +1,162 @@
+package fibre
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// struct Posture bundles the security defaults for a kind of service.
+// Start from PostureProfile and change fields to deviate from it, so the
+// deviations are explicit in configuration.
+type Posture struct {
+	Name string
+
+	// Headers are set on every response; handlers may override them.
+	Headers map[string]string
+
+	// RequestsPerMinute and Burst limit each client address; zero is
+	// unlimited.
+	RequestsPerMinute int
+	Burst             int
+
+	// RequireAPIKey and RequireAdminKey apply APIKeyMiddleware and
+	// AdminMiddleware to every request except those for PublicPaths.
+	RequireAPIKey   bool
+	RequireAdminKey bool
+	PublicPaths     []string
+
+	// CORS allows cross origin browser requests when set.
+	CORS *CORSConfig
+}
+
+var postures = map[string]Posture{
+	"public-site": {
+		Headers: map[string]string{
+			"Content-Security-Policy":   "default-src 'self'; frame-ancestors 'self'",
+			"Referrer-Policy":           "strict-origin-when-cross-origin",
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "SAMEORIGIN",
+		},
+		RequestsPerMinute: 600,
+		Burst:             100,
+		CORS:              &CORSConfig{Origins: []string{"*"}, MaxAge: time.Hour},
+	},
+	"internal-api": {
+		Headers: map[string]string{
+			"Cache-Control":             "no-store",
+			"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+			"Referrer-Policy":           "no-referrer",
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+		},
+		RequestsPerMinute: 1200,
+		Burst:             200,
+		RequireAPIKey:     true,
+		PublicPaths:       []string{"/healthcheck"},
+	},
+	"admin": {
+		Headers: map[string]string{
+			"Cache-Control":             "no-store",
+			"Content-Security-Policy":   "default-src 'self'; frame-ancestors 'none'",
+			"Referrer-Policy":           "no-referrer",
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+		},
+		RequestsPerMinute: 60,
+		Burst:             20,
+		RequireAdminKey:   true,
+		PublicPaths:       []string{"/healthcheck"},
+	},
+}
+
+// PostureProfiles returns the names of the built in posture profiles.
+func PostureProfiles() []string {
+	var names []string
+	for name := range postures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PostureProfile returns a copy of the named profile: "public-site",
+// "internal-api" or "admin".
+func PostureProfile(name string) (Posture, error) {
+	p, ok := postures[name]
+	if !ok {
+		return Posture{}, fmt.Errorf("fibre: unknown posture profile %q", name)
+	}
+	p.Name = name
+	headers := make(map[string]string, len(p.Headers))
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	p.Headers = headers
+	p.PublicPaths = append([]string(nil), p.PublicPaths...)
+	if p.CORS != nil {
+		cors := *p.CORS
+		cors.Origins = append([]string(nil), cors.Origins...)
+		p.CORS = &cors
+	}
+	return p, nil
+}
+
+// SecurityHeadersMiddleware returns middleware setting headers on every
+// response before the wrapped handler runs.
+func (ws *WebService) SecurityHeadersMiddleware(headers map[string]string) func(http.Handler) http.Handler {
+	// values are shared between responses, saving an allocation per header.
+	values := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		values[http.CanonicalHeaderKey(k)] = []string{v}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for k, v := range values {
+				h[k] = v
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// publicMiddleware applies auth to requests for paths other than public.
+func publicMiddleware(public []string, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		protected := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range public {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ApplyPosture installs the middleware for p ahead of routing: security
+// headers, CORS, per client rate limits and authentication, in that order.
+func (ws *WebService) ApplyPosture(p Posture) {
+	if len(p.Headers) > 0 {
+		ws.Wrap(ws.SecurityHeadersMiddleware(p.Headers))
+	}
+	if p.CORS != nil {
+		ws.Wrap(ws.CORSMiddleware(*p.CORS))
+	}
+	if p.RequestsPerMinute > 0 {
+		ws.Wrap(ws.RateLimitMiddleware(NewRateLimiter(p.RequestsPerMinute, p.Burst)))
+	}
+	if p.RequireAPIKey {
+		ws.Wrap(publicMiddleware(p.PublicPaths, ws.APIKeyMiddleware))
+	}
+	if p.RequireAdminKey {
+		ws.Wrap(publicMiddleware(p.PublicPaths, ws.AdminMiddleware))
+	}
+}
@@ -0,0 +1,91 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPostureProfileCopies(t *testing.T) {
+	p, err := PostureProfile("internal-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Headers["X-Frame-Options"] = "SAMEORIGIN"
+
+	q, _ := PostureProfile("internal-api")
+	if q.Headers["X-Frame-Options"] != "DENY" {
+		t.Errorf("PostureProfile returned a shared profile: got %v want %v", q.Headers["X-Frame-Options"], "DENY")
+	}
+
+	if _, err := PostureProfile("lax"); err == nil {
+		t.Errorf("PostureProfile accepted an unknown profile")
+	}
+}
+
+func TestInternalAPIPosture(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Apikey = "secretkey"
+	ws.Router.HandleFunc("/api/items", func(w http.ResponseWriter, r *http.Request) {})
+	p, _ := PostureProfile("internal-api")
+	p.Burst = 3
+	ws.ApplyPosture(p)
+	handler := ws.Handler()
+
+	tests := []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{"/healthcheck", "", http.StatusOK},
+		{"/api/items", "", http.StatusUnauthorized},
+		{"/api/items", "secretkey", http.StatusOK},
+		{"/api/items", "secretkey", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("api_key", tt.key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.path, status, tt.status)
+		}
+		if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%v is missing security headers", tt.path)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	ws.Router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := ws.CORSMiddleware(CORSConfig{Origins: []string{"https://example.com"}, Methods: []string{"GET", "POST"}, Credentials: true})(ws.Router)
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("CORSMiddleware returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("CORSMiddleware returned wrong origin: got %v want %v", got, "https://example.com")
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("CORSMiddleware returned wrong methods: got %v want %v", got, "GET, POST")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("CORSMiddleware allowed an unlisted origin: %v", got)
+	}
+}
@@ -0,0 +1,191 @@
+package fibre
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// staticRef matches references to static assets in templates.
+var staticRef = regexp.MustCompile(`(?:src|href)=["']/static/([^"'?#]+)`)
+
+// struct Preflight configures the checks RunPreflight makes on the
+// instance's pages, templates and static assets (web/<instance>/static).
+// Every page is parsed with the base template; Execute also renders them.
+// Assets lists files which must exist, and CheckReferences requires those
+// templates refer to with src="/static/..." or href="/static/..." too.
+type Preflight struct {
+	Execute         bool
+	Assets          []string
+	CheckReferences bool
+
+	// FailStartup refuses to start the server when preflight fails, rather
+	// than failing the critical "preflight" health check.
+	FailStartup bool
+}
+
+// struct PreflightProblem is a file which failed preflight.
+type PreflightProblem struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// struct PreflightReport is the outcome of RunPreflight.
+type PreflightReport struct {
+	OK       bool               `json:"ok"`
+	Time     time.Time          `json:"time"`
+	Pages    int                `json:"pages"`
+	Assets   int                `json:"assets"`
+	Problems []PreflightProblem `json:"problems"`
+}
+
+func (pr PreflightReport) Error() string {
+	msg := fmt.Sprintf("fibre: preflight found %d problems", len(pr.Problems))
+	for _, p := range pr.Problems {
+		msg += "\n  " + p.File + ": " + p.Error
+	}
+	return msg
+}
+
+// RunPreflight checks the instance's pages, templates and static assets
+// against p, returning a report of every problem found.
+func (ws *WebService) RunPreflight(p Preflight) PreflightReport {
+	report := PreflightReport{Time: time.Now(), Problems: []PreflightProblem{}}
+	problem := func(file string, err error) {
+		report.Problems = append(report.Problems, PreflightProblem{File: file, Error: err.Error()})
+	}
+
+	root := "web/" + ws.Instance
+	base := root + "/templates/base.html"
+	pages, _ := filepath.Glob(root + "/page/*.html")
+	assets := make(map[string]bool)
+	for _, a := range p.Assets {
+		assets[a] = true
+	}
+
+	if len(pages) > 0 {
+		if _, err := os.Stat(base); err != nil {
+			problem(base, err)
+			pages = nil
+		}
+	}
+	for _, page := range append([]string{base}, pages...) {
+		if p.CheckReferences {
+			if src, err := os.ReadFile(page); err == nil {
+				for _, m := range staticRef.FindAllStringSubmatch(string(src), -1) {
+					assets[m[1]] = true
+				}
+			}
+		}
+		if page == base {
+			continue
+		}
+
+		report.Pages++
+		tmpl, err := template.ParseFiles(page, base)
+		if err != nil {
+			problem(page, err)
+			continue
+		}
+		if p.Execute {
+			if err := tmpl.ExecuteTemplate(io.Discard, "base", struct{ Data string }{Data: "data"}); err != nil {
+				problem(page, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(assets))
+	for a := range assets {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	for _, a := range names {
+		report.Assets++
+		location, err := containedPath(root+"/static", a)
+		if err == nil {
+			_, err = os.Stat(location)
+		}
+		if err != nil {
+			problem(root+"/static/"+a, err)
+		}
+	}
+
+	report.OK = len(report.Problems) == 0
+	return report
+}
+
+// EnablePreflight runs preflight p at startup, and again whenever
+// ReloadTemplates finds changed files.  A failing preflight fails the
+// critical "preflight" health check, or with p.FailStartup stops the
+// server from starting.
+func (ws *WebService) EnablePreflight(p Preflight) {
+	ws.preflightMu.Lock()
+	ws.preflight = &p
+	ws.preflightMu.Unlock()
+
+	ws.OnStartup(Hook{Name: "preflight", Run: func(ctx context.Context) error {
+		report := ws.refreshPreflight()
+		if p.FailStartup && !report.OK {
+			return report
+		}
+		return nil
+	}})
+	ws.AddHealthCheck("preflight", true, func(ctx context.Context) error {
+		report, ok := ws.LastPreflight()
+		if ok && !report.OK {
+			return report
+		}
+		return nil
+	})
+}
+
+// refreshPreflight reruns the enabled preflight, keeping its report.
+func (ws *WebService) refreshPreflight() PreflightReport {
+	ws.preflightMu.Lock()
+	p := ws.preflight
+	ws.preflightMu.Unlock()
+	if p == nil {
+		return PreflightReport{OK: true}
+	}
+
+	report := ws.RunPreflight(*p)
+	if !report.OK {
+		ws.Metrics.Inc("preflight_failures")
+	}
+	ws.preflightMu.Lock()
+	ws.preflightReport = &report
+	ws.preflightMu.Unlock()
+	return report
+}
+
+// LastPreflight returns the latest report of the enabled preflight, and
+// whether one has run.
+func (ws *WebService) LastPreflight() (PreflightReport, bool) {
+	ws.preflightMu.Lock()
+	defer ws.preflightMu.Unlock()
+	if ws.preflightReport == nil {
+		return PreflightReport{}, false
+	}
+	return *ws.preflightReport, true
+}
+
+// PreflightHandler writes the latest preflight report as JSON, with 503
+// when it failed, for the admin router.
+func (ws *WebService) PreflightHandler(w http.ResponseWriter, r *http.Request) {
+	report, ok := ws.LastPreflight()
+	if !ok {
+		report = ws.refreshPreflight()
+	}
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	ws.writeJSON(w, report, status)
+}
@@ -0,0 +1,52 @@
+package fibre
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunPreflight(t *testing.T) {
+	ws := NewWebService("test", ":0")
+
+	report := ws.RunPreflight(Preflight{})
+	if !report.OK || report.Pages != 2 {
+		t.Errorf("preflight without Execute returned wrong report: got %+v", report)
+	}
+
+	report = ws.RunPreflight(Preflight{Execute: true, Assets: []string{"site.css", "../secret"}})
+	if report.OK || len(report.Problems) != 3 {
+		t.Fatalf("preflight returned wrong problems: got %+v", report.Problems)
+	}
+	if p := report.Problems[0]; p.File != "web/test/page/broken.html" || !strings.Contains(p.Error, "Missing") {
+		t.Errorf("preflight reported wrong template problem: got %+v", p)
+	}
+	if p := report.Problems[2]; p.File != "web/test/static/site.css" {
+		t.Errorf("preflight reported wrong asset problem: got %+v", p)
+	}
+}
+
+func TestEnablePreflight(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.EnablePreflight(Preflight{Execute: true})
+	if err := ws.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed without FailStartup: %v", err)
+	}
+	if _, healthy := ws.RunHealthChecks(context.Background()); healthy {
+		t.Errorf("health checks passed with a failing preflight")
+	}
+
+	w := httptest.NewRecorder()
+	ws.PreflightHandler(w, httptest.NewRequest("GET", "/admin/preflight", nil))
+	if status := w.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("PreflightHandler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	ws = NewWebService("test", ":0")
+	ws.EnablePreflight(Preflight{Execute: true, FailStartup: true})
+	if err := ws.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "broken.html") {
+		t.Errorf("Start returned wrong error with FailStartup: got %v", err)
+	}
+}
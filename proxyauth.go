@@ -0,0 +1,139 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTokenRefresh is how long before expiry ProxyAuth refreshes a
+// provided token when RefreshBefore is zero.
+const DefaultTokenRefresh = 30 * time.Second
+
+// struct ProxyAuth sets the credentials a proxy sends upstream, so clients
+// authenticate to fibre and fibre to the backends.  One of Bearer, Username
+// (with Password) or Token is used.  Token provides a token and its expiry
+// (zero for none); it is cached, refreshed RefreshBefore it expires, and
+// fetched again when the upstream answers a request without a body with
+// 401.  Tokens are sent in Header when set ("X-Api-Key", say), and
+// otherwise as an Authorization bearer token.  The client's own
+// Authorization header is never passed upstream.
+type ProxyAuth struct {
+	Bearer   string
+	Username string
+	Password string
+
+	Token         func(ctx context.Context) (token string, expires time.Time, err error)
+	RefreshBefore time.Duration
+	Header        string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// errNoToken reports a token provider which returned no token.
+var errNoToken = errors.New("fibre: proxy token provider returned no token")
+
+// check reports a problem with pa's configuration, for Validate.
+func (pa *ProxyAuth) check() error {
+	set := 0
+	for _, ok := range []bool{pa.Bearer != "", pa.Username != "", pa.Token != nil} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return errors.New("auth needs a Bearer, Username or Token")
+	case set > 1:
+		return errors.New("auth takes only one of Bearer, Username and Token")
+	case pa.Password != "" && pa.Username == "":
+		return errors.New("auth has a Password without a Username")
+	}
+	return nil
+}
+
+// current returns the cached token, fetching a new one when it is missing,
+// about to expire, or stale (the token the upstream refused).
+func (pa *ProxyAuth) current(ctx context.Context, stale string) (string, bool, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	refresh := orDefault(pa.RefreshBefore, DefaultTokenRefresh)
+	if pa.token != "" && pa.token != stale && (pa.expires.IsZero() || time.Until(pa.expires) > refresh) {
+		return pa.token, false, nil
+	}
+	token, expires, err := pa.Token(ctx)
+	if err == nil && token == "" {
+		err = errNoToken
+	}
+	if err != nil {
+		pa.token = ""
+		return "", false, err
+	}
+	pa.token, pa.expires = token, expires
+	return token, true, nil
+}
+
+// authTransport sets a proxy's upstream credentials on each request.
+type authTransport struct {
+	ws   *WebService
+	auth *ProxyAuth
+	next http.RoundTripper
+}
+
+// authorize sets req's credentials, returning the token used, if any.
+func (at *authTransport) authorize(req *http.Request, stale string) (string, error) {
+	pa := at.auth
+	if pa.Username != "" {
+		req.SetBasicAuth(pa.Username, pa.Password)
+		return "", nil
+	}
+
+	token := pa.Bearer
+	if pa.Token != nil {
+		var refreshed bool
+		var err error
+		token, refreshed, err = pa.current(req.Context(), stale)
+		if err != nil {
+			at.ws.Metrics.Inc("proxy_auth_errors")
+			return "", fmt.Errorf("proxy auth: %w", err)
+		}
+		if refreshed {
+			at.ws.Metrics.Inc("proxy_auth_refreshes")
+		}
+	}
+	if pa.Header != "" {
+		req.Header.Set(pa.Header, token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return token, nil
+}
+
+func (at *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the request is the proxy's own copy, with its own header map.
+	req.Header.Del("Authorization")
+	token, err := at.authorize(req, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := at.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || at.auth.Token == nil {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return resp, nil
+	}
+
+	// the upstream refused the token, perhaps revoked early; retry once
+	// with a fresh one.
+	resp.Body.Close()
+	if _, err := at.authorize(req, token); err != nil {
+		return nil, err
+	}
+	return at.next.RoundTrip(req)
+}
@@ -0,0 +1,129 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProxyAuthStatic(t *testing.T) {
+	var auth, apiKey string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, apiKey = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	tests := []struct {
+		auth       *ProxyAuth
+		wantAuth   string
+		wantAPIKey string
+	}{
+		{&ProxyAuth{Bearer: "s3cret"}, "Bearer s3cret", ""},
+		{&ProxyAuth{Username: "svc", Password: "pw"}, "Basic c3ZjOnB3", ""},
+		{&ProxyAuth{Bearer: "s3cret", Header: "X-Api-Key"}, "", "s3cret"},
+	}
+	for _, tt := range tests {
+		proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Auth: tt.auth})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer client")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("SetupProxy returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+		}
+		if auth != tt.wantAuth || apiKey != tt.wantAPIKey {
+			t.Errorf("SetupProxy sent wrong credentials: got %q, %q want %q, %q", auth, apiKey, tt.wantAuth, tt.wantAPIKey)
+		}
+	}
+}
+
+func TestProxyAuthTokenProvider(t *testing.T) {
+	revoked := ""
+	var seen []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth == "Bearer "+revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer backend.Close()
+
+	fetches := 0
+	expires := time.Now().Add(time.Hour)
+	pa := &ProxyAuth{Token: func(ctx context.Context) (string, time.Time, error) {
+		fetches++
+		return "token" + strconv.Itoa(fetches), expires, nil
+	}}
+	ws := NewWebService("test", "127.0.0.1:7999")
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Auth: pa})
+	get := func() int {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	get()
+	get()
+	if fetches != 1 || seen[1] != "Bearer token1" {
+		t.Errorf("ProxyAuth did not cache its token: %d fetches, sent %v", fetches, seen)
+	}
+
+	// an expiring token is refreshed ahead of time.
+	expires = time.Now().Add(10 * time.Second)
+	pa.mu.Lock()
+	pa.expires = expires
+	pa.mu.Unlock()
+	get()
+	if fetches != 2 || seen[2] != "Bearer token2" {
+		t.Errorf("ProxyAuth did not refresh an expiring token: %d fetches, sent %v", fetches, seen)
+	}
+
+	// a refused token is replaced and the request retried.
+	expires = time.Now().Add(time.Hour)
+	revoked = "token2"
+	if code := get(); code != http.StatusOK {
+		t.Errorf("SetupProxy returned wrong status code after refresh: got %v want %v", code, http.StatusOK)
+	}
+	if fetches != 3 || seen[len(seen)-1] != "Bearer token3" {
+		t.Errorf("ProxyAuth did not retry with a fresh token: %d fetches, sent %v", fetches, seen)
+	}
+	if n := ws.Metrics.Get("proxy_auth_refreshes"); n != 3 {
+		t.Errorf("proxy_auth_refreshes: got %v want %v", n, 3)
+	}
+}
+
+func TestProxyAuthTokenError(t *testing.T) {
+	called := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	pa := &ProxyAuth{Token: func(ctx context.Context) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("identity provider down")
+	}}
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Auth: pa})
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("SetupProxy returned wrong status code: got %v want %v", w.Code, http.StatusBadGateway)
+	}
+	if called {
+		t.Errorf("SetupProxy called the upstream without credentials")
+	}
+}
+
+func TestProxyAuthCheck(t *testing.T) {
+	for i, pa := range []*ProxyAuth{{}, {Bearer: "a", Username: "b"}, {Password: "pw", Bearer: "a"}} {
+		if err := pa.check(); err == nil {
+			t.Errorf("check accepted invalid auth %d", i)
+		}
+	}
+}
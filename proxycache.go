@@ -0,0 +1,299 @@
+package fibre
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for ProxyCache fields left zero.
+const (
+	DefaultProxyCacheMaxBytes   = 1 << 20
+	DefaultProxyCacheMaxEntries = 1000
+)
+
+// staleWarnings flag a stale response served in place of an upstream error.
+var staleWarnings = []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`}
+
+type cachedResponse struct {
+	path         string
+	status       int
+	header       http.Header
+	body         []byte
+	stored       time.Time
+	maxAge       time.Duration
+	staleIfError time.Duration
+}
+
+// struct ProxyCache keeps the last good (200) response to each GET sent to a
+// proxy's upstream.  Responses are served from the cache while fresh, for
+// TTL or the upstream's shorter s-maxage or max-age, and for StaleIfError
+// after that when the upstream fails (a connection error, 500, 502, 503 or
+// 504), flagged with Warning headers.  With no TTL, responses are only
+// served stale.  The upstream's Cache-Control decides what is kept:
+// no-store or private responses are not, and evict any earlier response,
+// no-cache ones are never fresh, and stale-if-error=<seconds> overrides
+// StaleIfError.  Responses over MaxBytes or setting cookies are not kept,
+// and requests with the client's Authorization or cookies bypass the cache.
+type ProxyCache struct {
+	TTL          time.Duration
+	StaleIfError time.Duration
+	MaxBytes     int64
+	MaxEntries   int
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// cacheControl parses the directives of a Cache-Control header, lower case.
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// seconds returns a delta-seconds directive as a duration.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	v, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheEncoding returns the Accept-Encoding a cached proxy asks its
+// upstream for: gzip when the client accepts it, otherwise none.  Keying
+// on it rather than the client's header lets every browser share one
+// variant.
+func cacheEncoding(header string) string {
+	if acceptsGzip(header) {
+		return "gzip"
+	}
+	return ""
+}
+
+func proxyCacheKey(req *http.Request) string {
+	return req.URL.String() + "\x00" + req.Header.Get("Accept-Encoding")
+}
+
+func (pc *ProxyCache) get(key string) *cachedResponse {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.entries[key]
+}
+
+func (pc *ProxyCache) store(key string, e *cachedResponse) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.entries == nil {
+		pc.entries = make(map[string]*cachedResponse)
+	}
+	if _, ok := pc.entries[key]; !ok && len(pc.entries) >= orDefault(pc.MaxEntries, DefaultProxyCacheMaxEntries) {
+		pc.evict()
+	}
+	pc.entries[key] = e
+}
+
+// evict drops expired responses, or the oldest when none have expired.
+func (pc *ProxyCache) evict() {
+	now := time.Now()
+	var oldest string
+	for k, e := range pc.entries {
+		if now.Sub(e.stored) > e.maxAge+e.staleIfError {
+			delete(pc.entries, k)
+		} else if oldest == "" || e.stored.Before(pc.entries[oldest].stored) {
+			oldest = k
+		}
+	}
+	if len(pc.entries) >= orDefault(pc.MaxEntries, DefaultProxyCacheMaxEntries) {
+		delete(pc.entries, oldest)
+	}
+}
+
+func (pc *ProxyCache) remove(key string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.entries, key)
+}
+
+// Purge removes the responses for URLs whose path begins with prefix,
+// returning the number removed.
+func (pc *ProxyCache) Purge(prefix string) int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	n := 0
+	for k, e := range pc.entries {
+		if strings.HasPrefix(e.path, prefix) {
+			delete(pc.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// response builds a response to req from e.
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.Itoa(int(time.Since(e.stored)/time.Second)))
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cachingBody keeps a copy of the upstream body as it is read, storing it
+// once read in full.
+type cachingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	done  func([]byte)
+}
+
+func (cb *cachingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	if cb.done != nil {
+		if int64(cb.buf.Len()+n) > cb.limit {
+			cb.done = nil
+		} else {
+			cb.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && cb.done != nil {
+		cb.done(cb.buf.Bytes())
+		cb.done = nil
+	}
+	return n, err
+}
+
+// cacheTransport serves a proxy's upstream responses from its ProxyCache.
+type cacheTransport struct {
+	ws     *WebService
+	cache  *ProxyCache
+	shared bool
+	next   http.RoundTripper
+}
+
+func upstreamFailed(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (ct *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// responses to the client's own credentials are not shared, unless the
+	// proxy replaces its credentials; the client's cookies always reach the
+	// upstream, so responses to them are never shared.
+	if req.Method != http.MethodGet || req.Header.Get("Cookie") != "" || (req.Header.Get("Authorization") != "" && !ct.shared) {
+		return ct.next.RoundTrip(req)
+	}
+	if enc := cacheEncoding(req.Header.Get("Accept-Encoding")); enc != req.Header.Get("Accept-Encoding") {
+		req = req.Clone(req.Context())
+		if enc == "" {
+			req.Header.Del("Accept-Encoding")
+		} else {
+			req.Header.Set("Accept-Encoding", enc)
+		}
+	}
+	key := proxyCacheKey(req)
+	cached := ct.cache.get(key)
+	_, noCache := cacheControl(req.Header)["no-cache"]
+	if cached != nil && !noCache && time.Since(cached.stored) < cached.maxAge {
+		ct.ws.Metrics.Inc("proxy_cache_hits")
+		return cached.response(req), nil
+	}
+
+	resp, err := ct.next.RoundTrip(req)
+	if (err != nil && !errors.Is(err, context.Canceled)) || (err == nil && upstreamFailed(resp.StatusCode)) {
+		if cached != nil && time.Since(cached.stored) < cached.maxAge+cached.staleIfError {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			ct.ws.Metrics.Inc("proxy_stale_served")
+			stale := cached.response(req)
+			for _, w := range staleWarnings {
+				stale.Header.Add("Warning", w)
+			}
+			return stale, nil
+		}
+		return resp, err
+	}
+	if err != nil {
+		return resp, err
+	}
+	ct.ws.Metrics.Inc("proxy_cache_misses")
+	ct.keep(key, req, resp)
+	return resp, nil
+}
+
+// keep arranges for resp to be cached once read, when the upstream allows.
+func (ct *cacheTransport) keep(key string, req *http.Request, resp *http.Response) {
+	directives := cacheControl(resp.Header)
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if noStore || private || resp.Header.Get("Set-Cookie") != "" {
+		ct.cache.remove(key)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" && !strings.EqualFold(h, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	limit := orDefault(ct.cache.MaxBytes, DefaultProxyCacheMaxBytes)
+	if resp.ContentLength > limit {
+		return
+	}
+
+	e := &cachedResponse{path: req.URL.Path, status: resp.StatusCode, header: resp.Header.Clone(), maxAge: ct.cache.TTL, staleIfError: ct.cache.StaleIfError}
+	if age, ok := seconds(directives, "s-maxage"); ok {
+		e.maxAge = min(age, e.maxAge)
+	} else if age, ok := seconds(directives, "max-age"); ok {
+		e.maxAge = min(age, e.maxAge)
+	}
+	if _, ok := directives["no-cache"]; ok {
+		e.maxAge = 0
+	}
+	if stale, ok := seconds(directives, "stale-if-error"); ok {
+		e.staleIfError = stale
+	}
+	if e.maxAge+e.staleIfError <= 0 {
+		return
+	}
+	resp.Body = &cachingBody{ReadCloser: resp.Body, limit: limit, done: func(body []byte) {
+		e.body = append([]byte(nil), body...)
+		e.stored = time.Now()
+		ct.cache.store(key, e)
+	}}
+}
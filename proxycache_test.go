@@ -0,0 +1,159 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyCacheStaleIfError(t *testing.T) {
+	status, calls := http.StatusOK, 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte("version 1"))
+		}
+	}))
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	cache := &ProxyCache{StaleIfError: time.Minute}
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Cache: cache})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/item"); w.Code != http.StatusOK || w.Header().Get("Warning") != "" {
+		t.Fatalf("SetupProxy returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	status = http.StatusServiceUnavailable
+	w := get("/item")
+	if w.Code != http.StatusOK || w.Body.String() != "version 1" {
+		t.Errorf("SetupProxy did not serve stale content on 503: got %v %q", w.Code, w.Body.String())
+	}
+	if warnings := w.Header().Values("Warning"); len(warnings) != 2 || warnings[0] != `110 - "Response is Stale"` {
+		t.Errorf("SetupProxy returned wrong Warning headers: %v", warnings)
+	}
+	if w := get("/other"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("SetupProxy returned wrong status code for uncached path: got %v want %v", w.Code, http.StatusServiceUnavailable)
+	}
+
+	backend.Close()
+	if w := get("/item"); w.Code != http.StatusOK || w.Body.String() != "version 1" {
+		t.Errorf("SetupProxy did not serve stale content when the upstream is down: got %v %q", w.Code, w.Body.String())
+	}
+	if n := ws.Metrics.Get("proxy_stale_served"); n != 2 {
+		t.Errorf("proxy_stale_served: got %v want %v", n, 2)
+	}
+
+	cache.mu.Lock()
+	for _, e := range cache.entries {
+		e.stored = time.Now().Add(-2 * time.Minute)
+	}
+	cache.mu.Unlock()
+	if w := get("/item"); w.Code != http.StatusBadGateway {
+		t.Errorf("SetupProxy served content past StaleIfError: got %v want %v", w.Code, http.StatusBadGateway)
+	}
+	if calls != 3 {
+		t.Errorf("SetupProxy made %d upstream calls, want 3", calls)
+	}
+}
+
+func TestProxyCacheFreshness(t *testing.T) {
+	calls := 0
+	cacheControl := "max-age=60"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Write([]byte("body"))
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	cache := &ProxyCache{TTL: time.Hour}
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Cache: cache})
+	get := func(noCache bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/item", nil)
+		if noCache {
+			req.Header.Set("Cache-Control", "no-cache")
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	get(false)
+	if w := get(false); calls != 1 || w.Body.String() != "body" || w.Header().Get("Age") == "" {
+		t.Errorf("SetupProxy did not serve a fresh cached response: %d calls, %q", calls, w.Body.String())
+	}
+	get(true)
+	if calls != 2 {
+		t.Errorf("SetupProxy served a cached response to no-cache: %d calls", calls)
+	}
+
+	// no-store from the upstream busts the cached response.
+	cacheControl = "no-store"
+	get(true)
+	get(false)
+	if calls != 4 {
+		t.Errorf("SetupProxy kept a no-store response: %d calls", calls)
+	}
+
+	cacheControl = "max-age=60"
+	get(false)
+	if n := cache.Purge("/it"); n != 1 {
+		t.Errorf("Purge removed %d responses, want 1", n)
+	}
+}
+
+func TestProxyCacheCookies(t *testing.T) {
+	calls := 0
+	setCookie := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=60")
+		if setCookie {
+			w.Header().Set("Set-Cookie", "session=abc")
+		}
+		w.Write([]byte("hello " + r.Header.Get("Cookie")))
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Cache: &ProxyCache{TTL: time.Hour}})
+	get := func(path, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	// a response to a client's cookie is not served to other clients.
+	get("/account", "session=alice")
+	if w := get("/account", ""); calls != 2 || strings.Contains(w.Body.String(), "alice") {
+		t.Errorf("SetupProxy cached a response to a request with cookies: %d calls, %q", calls, w.Body.String())
+	}
+
+	// a response setting a cookie is never kept.
+	setCookie = true
+	get("/login", "")
+	if w := get("/login", ""); calls != 4 || w.Header().Get("Age") != "" {
+		t.Errorf("SetupProxy cached a response setting a cookie: %d calls", calls)
+	}
+
+	// replacing the client's credentials does not share responses to cookies.
+	setCookie = false
+	proxy = ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Auth: &ProxyAuth{Bearer: "secret"}, Cache: &ProxyCache{TTL: time.Hour}})
+	get("/profile", "session=alice")
+	if w := get("/profile", ""); calls != 6 || strings.Contains(w.Body.String(), "alice") {
+		t.Errorf("SetupProxy with Auth cached a response to a request with cookies: %d calls, %q", calls, w.Body.String())
+	}
+}
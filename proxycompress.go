@@ -0,0 +1,154 @@
+package fibre
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressMinBytes is the smallest response ProxyCompression
+// recompresses when MinBytes is zero.
+const DefaultCompressMinBytes = 1024
+
+// defaultCompressTypes are recompressed when ProxyCompression.Types is empty.
+var defaultCompressTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// struct ProxyCompression negotiates compression on a proxy: the upstream
+// is always asked for gzip, and its responses are decompressed for clients
+// which do not accept gzip.  With Recompress, uncompressed responses of
+// Types (text, JSON, JavaScript, XML and SVG by default) of at least
+// MinBytes are gzipped for clients which do.
+type ProxyCompression struct {
+	Recompress bool
+	MinBytes   int64
+	Types      []string
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		q := strings.TrimSpace(params)
+		if v, ok := strings.CutPrefix(q, "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// negotiateCompression records whether the client accepts gzip; the proxy's
+// Director then asks the upstream for gzip on its outbound copy of the
+// request, leaving the client's headers as sent.
+func negotiateCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), gzipContextKey, acceptsGzip(r.Header.Get("Accept-Encoding")))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (pc *ProxyCompression) compressible(resp *http.Response) bool {
+	if resp.Request.Method == http.MethodHead || resp.StatusCode != http.StatusOK {
+		return false
+	}
+	min := pc.MinBytes
+	if min <= 0 {
+		min = DefaultCompressMinBytes
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < min {
+		return false
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	types := pc.Types
+	if len(types) == 0 {
+		types = defaultCompressTypes
+	}
+	for _, t := range types {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// gunzipBody closes both the gzip reader and the upstream body.
+type gunzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g *gunzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// gzipBody returns a reader of body gzipped as it is read.
+func gzipBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// compressProxyResponse returns a ModifyResponse func serving the encoding
+// negotiated by negotiateCompression.
+func (ws *WebService) compressProxyResponse(pc ProxyCompression) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if !strings.Contains(strings.ToLower(strings.Join(resp.Header.Values("Vary"), ",")), "accept-encoding") {
+			resp.Header.Add("Vary", "Accept-Encoding")
+		}
+		clientGzip, _ := resp.Request.Context().Value(gzipContextKey).(bool)
+		encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+
+		switch {
+		case encoding == "gzip" && !clientGzip && resp.Request.Method != http.MethodHead:
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return err
+			}
+			ws.Metrics.Inc("proxy_decompressed")
+			resp.Body = &gunzipBody{Reader: gz, body: resp.Body}
+		case encoding == "" && clientGzip && pc.Recompress && pc.compressible(resp):
+			ws.Metrics.Inc("proxy_compressed")
+			resp.Body = gzipBody(resp.Body)
+			resp.Header.Set("Content-Encoding", "gzip")
+		default:
+			return nil
+		}
+
+		if encoding != "" {
+			resp.Header.Del("Content-Encoding")
+		}
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			resp.Header.Set("ETag", "W/"+etag)
+		}
+		return nil
+	}
+}
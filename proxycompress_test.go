@@ -0,0 +1,93 @@
+package fibre
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"br, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) returned wrong result: got %v want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestProxyCompression(t *testing.T) {
+	body := strings.Repeat("fibre ", 500)
+	var upstreamEncoding string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/gzipped" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, body)
+			gz.Close()
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", ":0")
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Compression: &ProxyCompression{Recompress: true}})
+
+	tests := []struct {
+		path, accept, encoding string
+	}{
+		{"/gzipped", "", ""},
+		{"/gzipped", "gzip", "gzip"},
+		{"/plain", "gzip, br", "gzip"},
+		{"/plain", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if upstreamEncoding != "gzip" {
+			t.Errorf("upstream was asked for wrong encoding: got %q want %q", upstreamEncoding, "gzip")
+		}
+		if got := req.Header.Get("Accept-Encoding"); got != tt.accept {
+			t.Errorf("%v rewrote the client's Accept-Encoding: got %q want %q", tt.path, got, tt.accept)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%v with %q returned wrong encoding: got %q want %q", tt.path, tt.accept, got, tt.encoding)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%v returned wrong Vary: got %q want %q", tt.path, got, "Accept-Encoding")
+		}
+
+		var r io.Reader = w.Body
+		if tt.encoding == "gzip" {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%v returned an invalid gzip body: %v", tt.path, err)
+			}
+			r = gz
+		}
+		if got, _ := io.ReadAll(r); string(got) != body {
+			t.Errorf("%v with %q returned wrong body: got %d bytes want %d", tt.path, tt.accept, len(got), len(body))
+		}
+	}
+}
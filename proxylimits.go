@@ -0,0 +1,88 @@
+package fibre
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// forwardedHeaders carry client addresses and are stripped from inbound
+// requests unless ProxyConfig.TrustForwarded is set.  ReverseProxy sets
+// X-Forwarded-For itself from the connection.
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Origin-Host",
+	"X-Real-Ip",
+}
+
+// errResponseTooLarge is returned for upstream responses over
+// ProxyConfig.MaxResponseBytes.
+var errResponseTooLarge = errors.New("fibre: upstream response too large")
+
+// sanitizeProxyRequest drops spoofable and configured inbound headers.
+// Hop-by-hop headers are removed by ReverseProxy.
+func sanitizeProxyRequest(req *http.Request, config ProxyConfig) {
+	if !config.TrustForwarded {
+		for _, h := range forwardedHeaders {
+			req.Header.Del(h)
+		}
+	}
+	for _, h := range config.StripHeaders {
+		req.Header.Del(h)
+	}
+}
+
+// limitProxyRequest refuses request bodies over config.MaxRequestBytes with
+// 413, checking Content-Length up front and the body as it is read.
+func (ws *WebService) limitProxyRequest(config ProxyConfig, next http.Handler) http.Handler {
+	if config.MaxRequestBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > config.MaxRequestBytes {
+			ws.Metrics.Inc("proxy_request_too_large")
+			ws.JsonStatusResponse(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxRequestBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errResponseTooLarge
+	}
+	return n, err
+}
+
+// limitProxyResponse returns a ModifyResponse func refusing upstream
+// responses declared over max, and aborting those which grow past it.
+func limitProxyResponse(max int64) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.ContentLength > max {
+			return fmt.Errorf("%w: %d bytes", errResponseTooLarge, resp.ContentLength)
+		}
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: max}
+		return nil
+	}
+}
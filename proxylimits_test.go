@@ -0,0 +1,85 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyStripsForwardedHeaders(t *testing.T) {
+	var upstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	ws := new(WebService)
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, StripHeaders: []string{"Cookie"}})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := upstream.Get("X-Forwarded-For"); got != "192.0.2.1" {
+		t.Errorf("SetupProxy sent spoofed X-Forwarded-For: got %v want %v", got, "192.0.2.1")
+	}
+	for _, h := range []string{"X-Real-IP", "Cookie", "X-Hop"} {
+		if got := upstream.Get(h); got != "" {
+			t.Errorf("SetupProxy sent %v upstream: %v", h, got)
+		}
+	}
+}
+
+func TestProxyTrustForwarded(t *testing.T) {
+	var forwarded string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Forwarded-For")
+	}))
+	defer backend.Close()
+
+	ws := new(WebService)
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, TrustForwarded: true})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if forwarded != "10.0.0.1, 192.0.2.1" {
+		t.Errorf("SetupProxy sent wrong X-Forwarded-For: got %v want %v", forwarded, "10.0.0.1, 192.0.2.1")
+	}
+}
+
+func TestProxySizeLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer backend.Close()
+
+	ws := new(WebService)
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, MaxRequestBytes: 10, MaxResponseBytes: 50})
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{strings.Repeat("y", 20), http.StatusRequestEntityTooLarge},
+		{"small", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+
+		if status := rr.Code; status != tt.status {
+			t.Errorf("SetupProxy returned wrong status code for %d byte body: got %v want %v", len(tt.body), status, tt.status)
+		}
+	}
+}
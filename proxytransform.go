@@ -0,0 +1,233 @@
+package fibre
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultTransformMaxBytes bounds the bodies a proxy transforms when it has
+// no MaxRequestBytes or MaxResponseBytes of its own.
+const DefaultTransformMaxBytes = 10 << 20
+
+// BodyTransform rewrites a JSON body passing through a proxy.  The body is
+// decoded with json.Number for numbers, and the value returned is encoded
+// in its place.  FieldMapping is the declarative implementation; others
+// may be plugged in for anything it cannot express.
+type BodyTransform interface {
+	Transform(v interface{}) (interface{}, error)
+}
+
+// struct FieldMapping adapts JSON bodies between two contracts: Rename
+// moves fields to new names, Drop removes fields, and Defaults sets fields
+// which are missing, in that order.  Fields are named by dotted paths
+// ("user.email"); a path through an array applies to each of its elements,
+// so mappings written for an object also apply to a list of them.
+type FieldMapping struct {
+	Rename   map[string]string      `json:"rename,omitempty"`
+	Drop     []string               `json:"drop,omitempty"`
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+}
+
+// Transform applies the mapping to v.
+func (fm *FieldMapping) Transform(v interface{}) (interface{}, error) {
+	for from, to := range fm.Rename {
+		src, dst := splitPath(from), splitPath(to)
+		// the paths' common parents are walked together, so renames within
+		// array elements stay within each element.
+		common := 0
+		for common < len(src)-1 && common < len(dst)-1 && src[common] == dst[common] {
+			common++
+		}
+		eachObject(v, src[:common], false, func(obj map[string]interface{}) {
+			if value, ok := takePath(obj, src[common:]); ok {
+				setPath(obj, dst[common:], value)
+			}
+		})
+	}
+	for _, path := range fm.Drop {
+		p := splitPath(path)
+		eachObject(v, p[:len(p)-1], false, func(obj map[string]interface{}) {
+			delete(obj, p[len(p)-1])
+		})
+	}
+	for path, value := range fm.Defaults {
+		p := splitPath(path)
+		eachObject(v, p[:len(p)-1], true, func(obj map[string]interface{}) {
+			if _, ok := obj[p[len(p)-1]]; !ok {
+				obj[p[len(p)-1]] = value
+			}
+		})
+	}
+	return v, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// eachObject calls fn with each object at path within v, descending into
+// every element of the arrays on the way.  With create, missing objects
+// are added.
+func eachObject(v interface{}, path []string, create bool, fn func(map[string]interface{})) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			eachObject(elem, path, create, fn)
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			fn(v)
+			return
+		}
+		child, ok := v[path[0]]
+		if !ok && create {
+			child = make(map[string]interface{})
+			v[path[0]] = child
+		}
+		eachObject(child, path[1:], create, fn)
+	}
+}
+
+// takePath removes and returns the field path within obj.
+func takePath(obj map[string]interface{}, path []string) (interface{}, bool) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := obj[name].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = child
+	}
+	value, ok := obj[path[len(path)-1]]
+	delete(obj, path[len(path)-1])
+	return value, ok
+}
+
+// setPath sets the field path within obj, creating intermediate objects.
+func setPath(obj map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := obj[name].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			obj[name] = child
+		}
+		obj = child
+	}
+	obj[path[len(path)-1]] = value
+}
+
+// isJSON reports whether a Content-Type is JSON.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// errInvalidJSON reports a body which a BodyTransform could not decode.
+var errInvalidJSON = errors.New("fibre: invalid JSON body")
+
+// transformJSON decodes body, applies t and encodes the result.
+func transformJSON(t BodyTransform, body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidJSON, err)
+	}
+	v, err := t.Transform(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// transformProxyRequest applies config.RequestTransform to JSON request
+// bodies, answering 400 for invalid JSON.
+func (ws *WebService) transformProxyRequest(config ProxyConfig, next http.Handler) http.Handler {
+	if config.RequestTransform == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || !isJSON(r.Header.Get("Content-Type")) || r.Header.Get("Content-Encoding") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if config.MaxRequestBytes <= 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, DefaultTransformMaxBytes)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			body, err = transformJSON(config.RequestTransform, body)
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			ws.Metrics.Inc("proxy_request_too_large")
+			ws.JsonStatusResponse(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			ws.Metrics.Inc("proxy_transform_errors")
+			ws.JsonStatusResponse(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// transformProxyResponse returns a ModifyResponse func applying t to JSON
+// upstream responses, decoding gzip first.
+func (ws *WebService) transformProxyResponse(t BodyTransform, max int64) func(*http.Response) error {
+	if max <= 0 {
+		max = DefaultTransformMaxBytes
+	}
+	return func(resp *http.Response) error {
+		if resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || !isJSON(resp.Header.Get("Content-Type")) {
+			return nil
+		}
+		var body io.Reader = resp.Body
+		switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+		case "":
+		case "gzip":
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return err
+			}
+			body = gz
+		default:
+			return nil
+		}
+
+		data, err := io.ReadAll(io.LimitReader(body, max+1))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > max {
+			return fmt.Errorf("%w: over %d bytes to transform", errResponseTooLarge, max)
+		}
+		if len(data) > 0 {
+			if data, err = transformJSON(t, data); err != nil {
+				ws.Metrics.Inc("proxy_transform_errors")
+				return err
+			}
+		}
+
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		resp.Header.Del("Content-Encoding")
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			resp.Header.Set("ETag", "W/"+etag)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+package fibre
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFieldMapping(t *testing.T) {
+	fm := &FieldMapping{
+		Rename:   map[string]string{"user.mail": "user.email", "fullName": "name"},
+		Drop:     []string{"user.password", "internal"},
+		Defaults: map[string]interface{}{"version": 2, "user.role": "member"},
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{
+			`{"fullName": "Ada", "internal": true, "user": {"mail": "ada@example.com", "password": "x"}}`,
+			`{"name": "Ada", "version": 2, "user": {"email": "ada@example.com", "role": "member"}}`,
+		},
+		{
+			`[{"fullName": "Ada", "version": 1}, {"fullName": "Bob", "user": {"role": "admin"}}]`,
+			`[{"name": "Ada", "version": 1, "user": {"role": "member"}}, {"name": "Bob", "version": 2, "user": {"role": "admin"}}]`,
+		},
+		{`"scalar"`, `"scalar"`},
+	}
+	for _, tt := range tests {
+		out, err := transformJSON(fm, []byte(tt.in))
+		if err != nil {
+			t.Fatalf("transformJSON(%v) returned error: %v", tt.in, err)
+		}
+		var got, want interface{}
+		json.Unmarshal(out, &got)
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("transformJSON(%v): got %s want %s", tt.in, out, tt.want)
+		}
+	}
+
+	if _, err := transformJSON(fm, []byte(`{"broken`)); err == nil {
+		t.Errorf("transformJSON accepted invalid JSON")
+	}
+	if out, _ := transformJSON(fm, []byte(`{"id": 12345678901234567890}`)); !strings.Contains(string(out), `"id":12345678901234567890`) {
+		t.Errorf("transformJSON lost number precision: %s", out)
+	}
+}
+
+func TestProxyTransform(t *testing.T) {
+	var upstream string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		upstream = string(b)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, `{"display_name": "Ada", "secret": "x"}`)
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	proxy := ws.SetupProxy(ProxyConfig{
+		Path:              "/",
+		Host:              backend.URL,
+		RequestTransform:  &FieldMapping{Rename: map[string]string{"name": "display_name"}},
+		ResponseTransform: &FieldMapping{Rename: map[string]string{"display_name": "name"}, Drop: []string{"secret"}},
+	})
+
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name": "Ada"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("SetupProxy returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	if upstream != `{"display_name":"Ada"}` {
+		t.Errorf("SetupProxy sent wrong body upstream: got %v want %v", upstream, `{"display_name":"Ada"}`)
+	}
+	if body := w.Body.String(); body != `{"name":"Ada"}` {
+		t.Errorf("SetupProxy returned wrong body: got %v want %v", body, `{"name":"Ada"}`)
+	}
+	if etag := w.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("SetupProxy returned wrong ETag: got %v want %v", etag, `W/"v1"`)
+	}
+
+	req = httptest.NewRequest("POST", "/users", strings.NewReader(`{"name": `))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("SetupProxy returned wrong status code for invalid JSON: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+
+	// other content types pass through untouched.
+	req = httptest.NewRequest("POST", "/users", strings.NewReader(`name=Ada`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if upstream != `name=Ada` {
+		t.Errorf("SetupProxy transformed a form body: got %v", upstream)
+	}
+}
@@ -0,0 +1,81 @@
+package fibre
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// struct TransportConfig sets connection pooling for proxy upstreams.  Zero
+// values fall back to DefaultTransportConfig, except MaxConnsPerHost where
+// zero is unlimited.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// DefaultTransportConfig is used for proxies without a TransportConfig.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+func (c TransportConfig) withDefaults() TransportConfig {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = DefaultTransportConfig.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = DefaultTransportConfig.MaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = DefaultTransportConfig.IdleConnTimeout
+	}
+	return c
+}
+
+type transportKey struct {
+	scheme string
+	host   string
+	config TransportConfig
+}
+
+// proxyTransport returns the transport for upstream, shared by every proxy
+// to the same host with the same pooling configuration.  The config is
+// taken from the ProxyConfig, then ws.ProxyTransport.
+func (ws *WebService) proxyTransport(upstream *url.URL, config *TransportConfig) *http.Transport {
+	if config == nil {
+		config = ws.ProxyTransport
+	}
+	var c TransportConfig
+	if config != nil {
+		c = *config
+	}
+	c = c.withDefaults()
+	key := transportKey{scheme: upstream.Scheme, host: upstream.Host, config: c}
+
+	ws.transportsMu.Lock()
+	defer ws.transportsMu.Unlock()
+	if t, ok := ws.transports[key]; ok {
+		return t
+	}
+	t := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeout:     c.IdleConnTimeout,
+	}
+	if ws.transports == nil {
+		ws.transports = make(map[transportKey]*http.Transport)
+	}
+	ws.transports[key] = t
+	return t
+}
@@ -0,0 +1,57 @@
+package fibre
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProxyTransportShared(t *testing.T) {
+	ws := new(WebService)
+	ws.ProxyTransport = &TransportConfig{MaxConnsPerHost: 8}
+
+	ws.SetupProxy(ProxyConfig{Path: "/a", Host: "http://backend:8080"})
+	ws.SetupProxy(ProxyConfig{Path: "/b", Host: "http://backend:8080/b"})
+	ws.SetupProxy(ProxyConfig{Path: "/c", Host: "http://other:8080"})
+	if len(ws.transports) != 2 {
+		t.Errorf("SetupProxy created wrong number of transports: got %v want %v", len(ws.transports), 2)
+	}
+
+	upstream, _ := url.Parse("http://backend:8080")
+	shared := ws.proxyTransport(upstream, nil)
+	if shared.MaxConnsPerHost != 8 || shared.MaxIdleConnsPerHost != DefaultTransportConfig.MaxIdleConnsPerHost {
+		t.Errorf("proxyTransport returned wrong pooling: got %v, %v", shared.MaxConnsPerHost, shared.MaxIdleConnsPerHost)
+	}
+
+	own := ws.proxyTransport(upstream, &TransportConfig{IdleConnTimeout: time.Second})
+	if own == shared || own.IdleConnTimeout != time.Second {
+		t.Errorf("proxyTransport shared a transport with different pooling")
+	}
+}
+
+func TestProxyTransportReusesConnections(t *testing.T) {
+	conns := 0
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns++
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	ws := new(WebService)
+	a := ws.SetupProxy(ProxyConfig{Path: "/a", Host: backend.URL})
+	b := ws.SetupProxy(ProxyConfig{Path: "/b", Host: backend.URL})
+	for i := 0; i < 3; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+		b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/b", nil))
+	}
+
+	if conns != 1 {
+		t.Errorf("SetupProxy opened wrong number of upstream connections: got %v want %v", conns, 1)
+	}
+}
@@ -0,0 +1,279 @@
+package fibre
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// quotaSaveInterval is how often PersistQuotas writes changed counters.
+const quotaSaveInterval = 10 * time.Second
+
+// struct QuotaUsage counts a key's requests in the current day and month
+// (UTC).
+type QuotaUsage struct {
+	Day     string `json:"day"`
+	Daily   int64  `json:"daily"`
+	Month   string `json:"month"`
+	Monthly int64  `json:"monthly"`
+}
+
+// struct QuotaStatus describes the quota a request was checked against.
+type QuotaStatus struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// struct Quotas tracks daily and monthly request counts per API key,
+// persisted as JSON at Path by Save, or PersistQuotas, so counts survive
+// restarts.  Keys are stored hashed.
+type Quotas struct {
+	Path string
+
+	mu     sync.Mutex
+	usage  map[string]*QuotaUsage
+	dirty  bool
+	saveMu sync.Mutex
+}
+
+// NewQuotas returns quota counters backed by path, loading any counts saved
+// there.  An empty path keeps counts in memory only.
+func NewQuotas(path string) (*Quotas, error) {
+	q := &Quotas{Path: path, usage: make(map[string]*QuotaUsage)}
+	if path == "" {
+		return q, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &q.usage); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func quotaID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// current returns the usage for id rolled over to the period containing now.
+func (q *Quotas) current(id string, now time.Time) *QuotaUsage {
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	u, ok := q.usage[id]
+	if !ok {
+		u = &QuotaUsage{}
+		q.usage[id] = u
+	}
+	if u.Day != day {
+		u.Day, u.Daily = day, 0
+	}
+	if u.Month != month {
+		u.Month, u.Monthly = month, 0
+	}
+	return u
+}
+
+// quotaStatus returns the tighter of the daily and monthly quotas for t.
+func quotaStatus(u *QuotaUsage, t Tier, now time.Time) (QuotaStatus, bool) {
+	y, m, d := now.Date()
+	var st QuotaStatus
+	limited := false
+	if t.DailyQuota > 0 {
+		st = QuotaStatus{
+			Limit:     t.DailyQuota,
+			Remaining: t.DailyQuota - u.Daily,
+			Reset:     time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC),
+		}
+		limited = true
+	}
+	if t.MonthlyQuota > 0 && (!limited || t.MonthlyQuota-u.Monthly < st.Remaining) {
+		st = QuotaStatus{
+			Limit:     t.MonthlyQuota,
+			Remaining: t.MonthlyQuota - u.Monthly,
+			Reset:     time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		limited = true
+	}
+	if st.Remaining < 0 {
+		st.Remaining = 0
+	}
+	return st, limited
+}
+
+// Use counts a request for key against the quotas of t, returning the quota
+// status and whether the request is within quota.  Refused requests are not
+// counted.
+func (q *Quotas) Use(key string, t Tier) (QuotaStatus, bool) {
+	now := time.Now().UTC()
+	q.mu.Lock()
+	u := q.current(quotaID(key), now)
+	st, limited := quotaStatus(u, t, now)
+	if limited && st.Remaining <= 0 {
+		q.mu.Unlock()
+		return st, false
+	}
+	u.Daily++
+	u.Monthly++
+	if limited {
+		st.Remaining--
+	}
+	q.dirty = true
+	q.mu.Unlock()
+	return st, true
+}
+
+// Usage returns the counts for key in the current period.
+func (q *Quotas) Usage(key string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.current(quotaID(key), time.Now().UTC())
+}
+
+// Rename moves the counts for key to fresh, when a key is rotated.
+func (q *Quotas) Rename(key, fresh string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u, ok := q.usage[quotaID(key)]; ok {
+		delete(q.usage, quotaID(key))
+		q.usage[quotaID(fresh)] = u
+		q.dirty = true
+	}
+}
+
+// Save writes the counters to Path if they changed since the last save.
+// Counters which fail to save are saved again on the next call.
+func (q *Quotas) Save() error {
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	if q.Path == "" || !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(q.usage)
+	q.dirty = false
+	q.mu.Unlock()
+
+	if err == nil {
+		tmp := q.Path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, q.Path)
+		}
+	}
+	if err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// saveQuotas saves q, logging and counting failures.
+func (ws *WebService) saveQuotas(q *Quotas) {
+	if err := q.Save(); err != nil {
+		ws.Metrics.Inc("quota_save_failures")
+		if ws.logs(LogError) {
+			log.Printf("%v saving quotas: %v", ws.Instance, err)
+		}
+	}
+}
+
+// PersistQuotas saves q's counters every quotaSaveInterval once the
+// instance starts, off the request path, and a last time when it shuts
+// down.
+func (ws *WebService) PersistQuotas(q *Quotas) {
+	var (
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	)
+	ws.OnStartup(Hook{Name: "quotas", Run: func(context.Context) error {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(quotaSaveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					ws.saveQuotas(q)
+				}
+			}
+		}()
+		return nil
+	}})
+	ws.OnShutdown(Hook{Name: "quotas", Run: func(context.Context) error {
+		if cancel != nil {
+			cancel()
+			wg.Wait()
+		}
+		ws.saveQuotas(q)
+		return nil
+	}})
+}
+
+// setQuotaHeaders describes st on the response.
+func setQuotaHeaders(w http.ResponseWriter, st QuotaStatus) {
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(st.Limit, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(st.Remaining, 10))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(st.Reset.Unix(), 10))
+}
+
+// UsageHandler reports the calling key's tier, limits and consumption.  It
+// authenticates the api_key header itself, so callers over quota can still
+// check their usage; register it outside of APIKeyMiddleware.
+func (ws *WebService) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	apik := r.Header.Get("api_key")
+	if ws.Keys == nil {
+		ws.JsonStatusResponse(w, "Invalid api_key", http.StatusUnauthorized)
+		return
+	}
+	key, ok := ws.Keys.Lookup(apik)
+	if len(apik) == 0 || !ok {
+		ws.JsonStatusResponse(w, "Invalid api_key", http.StatusUnauthorized)
+		return
+	}
+
+	tier, _ := ws.Keys.Tier(key.Tier)
+	response := struct {
+		Name              string      `json:"name"`
+		Tier              string      `json:"tier"`
+		RequestsPerMinute int         `json:"requests_per_minute"`
+		Concurrency       int         `json:"concurrency"`
+		DailyQuota        int64       `json:"daily_quota"`
+		MonthlyQuota      int64       `json:"monthly_quota"`
+		Usage             *QuotaUsage `json:"usage,omitempty"`
+	}{
+		Name:              key.Name,
+		Tier:              key.Tier,
+		RequestsPerMinute: tier.RequestsPerMinute,
+		Concurrency:       tier.Concurrency,
+		DailyQuota:        tier.DailyQuota,
+		MonthlyQuota:      tier.MonthlyQuota,
+	}
+	if ws.Keys.Quotas != nil {
+		usage := ws.Keys.Quotas.Usage(apik)
+		response.Usage = &usage
+		if st, limited := quotaStatus(&usage, tier, time.Now().UTC()); limited {
+			setQuotaHeaders(w, st)
+		}
+	}
+	ws.writeJSON(w, response, http.StatusOK)
+}
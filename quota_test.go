@@ -0,0 +1,138 @@
+package fibre
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuotasPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	q, err := NewQuotas(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tier := Tier{Name: "free", DailyQuota: 2}
+	for i, want := range []bool{true, true, false} {
+		if _, ok := q.Use("k", tier); ok != want {
+			t.Errorf("Quotas.Use request %v returned %v want %v", i, ok, want)
+		}
+	}
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = NewQuotas(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := q.Usage("k"); u.Daily != 2 || u.Monthly != 2 {
+		t.Errorf("Quotas did not persist counts: got %+v", u)
+	}
+}
+
+func TestPersistQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	q, _ := NewQuotas(path)
+	ws := NewWebService("test", ":0")
+	ws.PersistQuotas(q)
+	if err := ws.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Use("k", Tier{Name: "free"})
+	if err := ws.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	q, _ = NewQuotas(path)
+	if u := q.Usage("k"); u.Daily != 1 {
+		t.Errorf("PersistQuotas did not save counts on shutdown: got %+v", u)
+	}
+}
+
+func TestQuotasSaveFailure(t *testing.T) {
+	q, _ := NewQuotas(filepath.Join(t.TempDir(), "missing", "quota.json"))
+	ws := NewWebService("test", ":0")
+	q.Use("k", Tier{Name: "free"})
+	ws.saveQuotas(q)
+	if got := ws.Metrics.Get("quota_save_failures"); got != 1 {
+		t.Errorf("saveQuotas counted wrong failures: got %v want %v", got, 1)
+	}
+	if !q.dirty {
+		t.Errorf("Quotas.Save dropped counters which failed to save")
+	}
+}
+
+func TestAPIKeyMiddlewareQuota(t *testing.T) {
+	ws := new(WebService)
+	ws.Keys = NewKeyStore()
+	ws.Keys.Quotas, _ = NewQuotas("")
+	ws.Keys.SetTier(Tier{Name: "free", DailyQuota: 1, MonthlyQuota: 10})
+	ws.Keys.AddKey(APIKey{Key: "k1", Name: "alice", Tier: "free"})
+
+	handler := ws.APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("api_key", "k1")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("APIKeyMiddleware returned wrong status code over quota: got %v want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("X-Quota-Limit") != "1" || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Errorf("APIKeyMiddleware returned unexpected quota headers: %v", w.Header())
+	}
+
+	req := httptest.NewRequest("GET", "/usage", nil)
+	req.Header.Set("api_key", "k1")
+	w = httptest.NewRecorder()
+	ws.UsageHandler(w, req)
+
+	var usage struct {
+		Tier  string
+		Usage QuotaUsage
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Tier != "free" || usage.Usage.Daily != 1 {
+		t.Errorf("UsageHandler returned unexpected usage: %+v", usage)
+	}
+}
+
+func TestAPIKeyMiddlewareQuotaRefund(t *testing.T) {
+	ws := new(WebService)
+	ws.Keys = NewKeyStore()
+	ws.Keys.Quotas, _ = NewQuotas("")
+	ws.Keys.SetTier(Tier{Name: "free", RequestsPerMinute: 1, Burst: 2, DailyQuota: 1})
+	ws.Keys.AddKey(APIKey{Key: "k1", Name: "alice", Tier: "free"})
+
+	handler := ws.APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("api_key", "k1")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+	}
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Errorf("APIKeyMiddleware returned wrong response over quota: got %v %v", w.Code, w.Header())
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("APIKeyMiddleware spent rate limit tokens over quota: got %v remaining want %v", got, 1)
+	}
+	if usage := ws.Keys.Usage(); usage[0].Requests != 1 {
+		t.Errorf("APIKeyMiddleware counted requests refused over quota: got %v want %v", usage[0].Requests, 1)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package fibre
+
+// raceEnabled skips allocation tests, as sync.Pool drops items at random
+// under the race detector.
+const raceEnabled = true
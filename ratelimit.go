@@ -0,0 +1,146 @@
+package fibre
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxBuckets bounds the number of idle buckets a RateLimiter keeps before
+// pruning full ones.
+const maxBuckets = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// struct RateLimiter is a token bucket limiter keyed by string (an API key,
+// client address, etc).  Each key may make Burst requests at once, refilled
+// at PerMinute requests per minute.
+type RateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	burst     int
+	buckets   map[string]*bucket
+}
+
+// NewRateLimiter returns a limiter allowing perMinute requests per minute
+// with bursts of burst; burst defaults to perMinute when zero.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &RateLimiter{perMinute: perMinute, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// Limits returns the configured requests per minute and burst.
+func (rl *RateLimiter) Limits() (perMinute, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.perMinute, rl.burst
+}
+
+// SetLimits changes the limits, keeping existing buckets.
+func (rl *RateLimiter) SetLimits(perMinute, burst int) {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	rl.mu.Lock()
+	rl.perMinute, rl.burst = perMinute, burst
+	rl.mu.Unlock()
+}
+
+// Allow takes a token for key, returning whether the request may proceed,
+// the tokens remaining and, when refused, how long until a token is free.
+// A limiter with no rate allows everything.
+func (rl *RateLimiter) Allow(key string) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.perMinute <= 0 {
+		return true, 0, 0
+	}
+
+	now := time.Now()
+	rate := float64(rl.perMinute) / 60
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxBuckets {
+			rl.prune(now, rate)
+		}
+		b = &bucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(rl.burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// refund returns a token taken by Allow for key, for a request refused
+// after all, returning the tokens remaining.
+func (rl *RateLimiter) refund(key string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		return 0
+	}
+	b.tokens = math.Min(float64(rl.burst), b.tokens+1)
+	return int(b.tokens)
+}
+
+// prune drops buckets which would have refilled completely.
+func (rl *RateLimiter) prune(now time.Time, rate float64) {
+	for k, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(rl.burst) {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// clientIP returns the address of the client connection, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitMiddleware returns middleware which limits each client address
+// with rl, refusing requests over the limit with 429 and Retry-After.  rl's
+// rate may be changed at runtime through ConfigHandler.
+func (ws *WebService) RateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
+	ws.rateLimitersMu.Lock()
+	if !slices.Contains(ws.rateLimiters, rl) {
+		ws.rateLimiters = append(ws.rateLimiters, rl)
+	}
+	ws.rateLimitersMu.Unlock()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, remaining, retry := rl.Allow(clientIP(r))
+			if perMinute, _ := rl.Limits(); perMinute > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(perMinute))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			}
+			if !allowed {
+				ws.Metrics.Inc("rate_limited")
+				w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)+1))
+				ws.JsonStatusResponse(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
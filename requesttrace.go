@@ -0,0 +1,251 @@
+package fibre
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultRequestTraceSize is the number of requests a RequestTrace keeps
+// when NewRequestTrace is given no size.
+const DefaultRequestTraceSize = 500
+
+// requestTracePath is where EnableRequestTrace serves the trace; requests
+// to it are not traced.
+const requestTracePath = "/debug/requests"
+
+// struct RequestSummary describes one served request.  RequestBytes is the
+// body read by the handlers, and Error is set for panics and 5xx responses.
+type RequestSummary struct {
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	Route         string        `json:"route"`
+	Path          string        `json:"path"`
+	Status        int           `json:"status"`
+	Duration      time.Duration `json:"duration"`
+	RequestBytes  int64         `json:"request_bytes"`
+	ResponseBytes int64         `json:"response_bytes"`
+	Remote        string        `json:"remote"`
+	RequestID     string        `json:"request_id,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// struct RequestTrace keeps the most recent requests served, for triage
+// with RequestTraceHandler.  A nil *RequestTrace records nothing.
+type RequestTrace struct {
+	mu     sync.Mutex
+	recent []RequestSummary
+	next   int
+	size   int
+}
+
+// NewRequestTrace returns a trace keeping the last size requests, or
+// DefaultRequestTraceSize when size is zero.
+func NewRequestTrace(size int) *RequestTrace {
+	return &RequestTrace{size: orDefault(size, DefaultRequestTraceSize)}
+}
+
+// Record adds s, displacing the oldest request once the trace is full.
+func (rt *RequestTrace) Record(s RequestSummary) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if len(rt.recent) < rt.size {
+		rt.recent = append(rt.recent, s)
+	} else {
+		rt.recent[rt.next] = s
+	}
+	rt.next = (rt.next + 1) % rt.size
+}
+
+// Recent returns the requests kept, newest first.
+func (rt *RequestTrace) Recent() []RequestSummary {
+	if rt == nil {
+		return []RequestSummary{}
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	summaries := make([]RequestSummary, 0, len(rt.recent))
+	for i := 1; i <= len(rt.recent); i++ {
+		summaries = append(summaries, rt.recent[(rt.next-i+len(rt.recent))%len(rt.recent)])
+	}
+	return summaries
+}
+
+// countingBody counts the request body read by the handlers.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.n += int64(n)
+	return n, err
+}
+
+// traceRequest records a request served by serveMiddleware in ws.Requests.
+func (ws *WebService) traceRequest(r *http.Request, sw *SizeWriter, route string, start time.Time, body *countingBody, panicked interface{}) {
+	if route == requestTracePath {
+		return
+	}
+	s := RequestSummary{
+		Time:          start,
+		Method:        r.Method,
+		Route:         route,
+		Path:          r.URL.Path,
+		Status:        sw.Status,
+		Duration:      time.Since(start),
+		ResponseBytes: sw.Bytes,
+		Remote:        clientIP(r),
+		RequestID:     r.Header.Get("X-Request-ID"),
+	}
+	if s.Status == 0 {
+		s.Status = http.StatusOK
+	}
+	if body != nil {
+		s.RequestBytes = body.n
+	}
+	switch {
+	case panicked != nil && panicked != http.ErrAbortHandler:
+		s.Error = fmt.Sprint("panic: ", panicked)
+	case panicked == http.ErrAbortHandler:
+		s.Error = "aborted"
+	case s.Status >= 500:
+		s.Error = http.StatusText(s.Status)
+	}
+	ws.Requests.Record(s)
+}
+
+// struct RouteTraceSummary aggregates the traced requests of one route.
+type RouteTraceSummary struct {
+	Route    string        `json:"route"`
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Mean     time.Duration `json:"mean"`
+	Max      time.Duration `json:"max"`
+}
+
+// summariseRoutes aggregates summaries by method and route, busiest first.
+func summariseRoutes(summaries []RequestSummary) []RouteTraceSummary {
+	index := make(map[string]int)
+	var routes []RouteTraceSummary
+	var total []time.Duration
+	for _, s := range summaries {
+		key := s.Method + " " + s.Route
+		i, ok := index[key]
+		if !ok {
+			i = len(routes)
+			index[key] = i
+			routes = append(routes, RouteTraceSummary{Route: key})
+			total = append(total, 0)
+		}
+		routes[i].Requests++
+		if s.Error != "" {
+			routes[i].Errors++
+		}
+		total[i] += s.Duration
+		routes[i].Max = max(routes[i].Max, s.Duration)
+	}
+	for i := range routes {
+		routes[i].Mean = total[i] / time.Duration(routes[i].Requests)
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Requests > routes[j].Requests })
+	return routes
+}
+
+var requestTraceTemplate = template.Must(template.New("requests").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Instance}} requests</title>
+  <style>
+    body { font-family: sans-serif; font-size: 14px; }
+    table { border-collapse: collapse; margin-bottom: 2em; }
+    th, td { padding: 2px 8px; text-align: left; }
+    tr:nth-child(even) { background: #f4f4f4; }
+    .error { color: #b00; }
+  </style>
+</head>
+<body>
+  <h1>{{.Instance}} requests</h1>
+  <p>
+    <a href="?">all</a> |
+    <a href="?errors">errors</a> |
+    <a href="?min=100ms">&ge;100ms</a> |
+    <a href="?min=1s">&ge;1s</a> |
+    <a href="?format=json">json</a>
+  </p>
+  <h2>Routes</h2>
+  <table>
+    <tr><th>Route</th><th>Requests</th><th>Errors</th><th>Mean</th><th>Max</th></tr>
+    {{range .Routes}}
+    <tr><td><a href="?route={{.Route}}">{{.Route}}</a></td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{.Mean}}</td><td>{{.Max}}</td></tr>
+    {{end}}
+  </table>
+  <h2>Recent</h2>
+  <table>
+    <tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Duration</th><th>In</th><th>Out</th><th>Remote</th><th>Request ID</th><th>Error</th></tr>
+    {{range .Requests}}
+    <tr{{if .Error}} class="error"{{end}}><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Method}}</td><td title="{{.Route}}">{{.Path}}</td><td>{{.Status}}</td><td>{{.Duration}}</td><td>{{.RequestBytes}}</td><td>{{.ResponseBytes}}</td><td>{{.Remote}}</td><td>{{.RequestID}}</td><td>{{.Error}}</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// RequestTraceHandler lists the requests in ws.Requests, newest first, as
+// an HTML page or, for ?format=json or clients accepting JSON, as JSON.
+// ?errors, ?route=<method> <route> and ?min=<duration> filter the list.
+func (ws *WebService) RequestTraceHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var minDuration time.Duration
+	if v := q.Get("min"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			ws.JsonStatusResponse(w, "Invalid min duration", http.StatusBadRequest)
+			return
+		}
+		minDuration = d
+	}
+	route := q.Get("route")
+	errorsOnly := q.Has("errors")
+
+	summaries := ws.Requests.Recent()
+	filtered := summaries[:0]
+	for _, s := range summaries {
+		if (errorsOnly && s.Error == "") || s.Duration < minDuration || (route != "" && s.Method+" "+s.Route != route) {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if q.Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		ws.writeJSON(w, filtered, http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	requestTraceTemplate.Execute(w, struct {
+		Instance string
+		Routes   []RouteTraceSummary
+		Requests []RequestSummary
+	}{ws.Instance, summariseRoutes(filtered), filtered})
+}
+
+// EnableRequestTrace keeps the last size requests (DefaultRequestTraceSize
+// when zero) in ws.Requests, and registers RequestTraceHandler at
+// /debug/requests, protected by AdminMiddleware.
+func (ws *WebService) EnableRequestTrace(size int) *mux.Route {
+	ws.Requests = NewRequestTrace(size)
+	return ws.Router.Handle(requestTracePath, ws.AdminMiddleware(http.HandlerFunc(ws.RequestTraceHandler))).Methods(http.MethodGet)
+}
@@ -0,0 +1,95 @@
+package fibre
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestTrace(t *testing.T) {
+	rt := NewRequestTrace(3)
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		rt.Record(RequestSummary{Path: path})
+	}
+	recent := rt.Recent()
+	if len(recent) != 3 || recent[0].Path != "/d" || recent[2].Path != "/b" {
+		t.Errorf("Recent returned unexpected requests: %+v", recent)
+	}
+
+	var nilTrace *RequestTrace
+	nilTrace.Record(RequestSummary{Path: "/a"})
+	if len(nilTrace.Recent()) != 0 {
+		t.Errorf("nil RequestTrace recorded a request")
+	}
+}
+
+func TestRequestTraceHandler(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.AdminKey = "admin"
+	ws.EnableRequestTrace(0)
+	ws.Router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "item")
+	})
+	ws.Router.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := ws.Handler()
+
+	req := httptest.NewRequest("POST", "/items/7", strings.NewReader("hello"))
+	req.Header.Set("X-Request-ID", "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/boom", nil))
+
+	req = httptest.NewRequest("GET", "/debug/requests", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("RequestTraceHandler returned wrong status code without admin_key: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("GET", "/debug/requests?format=json", nil)
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("RequestTraceHandler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var summaries []RequestSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("RequestTraceHandler returned %d requests, want 2: %+v", len(summaries), summaries)
+	}
+	boom, item := summaries[0], summaries[1]
+	if boom.Status != http.StatusInternalServerError || boom.Error != "panic: boom" {
+		t.Errorf("RequestTraceHandler returned unexpected panic summary: %+v", boom)
+	}
+	if item.Route != "/items/{id}" || item.Path != "/items/7" || item.RequestBytes != 5 || item.ResponseBytes != 4 || item.RequestID != "abc" {
+		t.Errorf("RequestTraceHandler returned unexpected summary: %+v", item)
+	}
+
+	req = httptest.NewRequest("GET", "/debug/requests?errors", nil)
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body := w.Body.String()
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("RequestTraceHandler returned wrong content type: got %v want text/html", ct)
+	}
+	if !strings.Contains(body, "/boom") || strings.Contains(body, "/items/7") {
+		t.Errorf("RequestTraceHandler did not filter errors: %v", body)
+	}
+
+	req = httptest.NewRequest("GET", "/debug/requests?min=bogus", nil)
+	req.Header.Set("admin_key", "admin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("RequestTraceHandler returned wrong status code for bad min: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,100 @@
+package fibre
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseSizeBuckets name the response size counters, by upper bound.
+var responseSizeBuckets = []struct {
+	name  string
+	limit int64
+}{
+	{"response_size_le_1k", 1 << 10},
+	{"response_size_le_10k", 10 << 10},
+	{"response_size_le_100k", 100 << 10},
+	{"response_size_le_1m", 1 << 20},
+}
+
+// struct SizeWriter records the status and the number of body bytes written
+// through it.
+type SizeWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+
+	// Route is the template of the route which served the request, once
+	// routed; see RouteTemplate.
+	Route string
+
+	// inFlight marks the writer serveMiddleware counts the request with.
+	inFlight bool
+}
+
+// NewSizeWriter wraps w to count the bytes written.
+func NewSizeWriter(w http.ResponseWriter) *SizeWriter {
+	return &SizeWriter{ResponseWriter: w}
+}
+
+// WriteHeader records status, ignoring informational responses.
+func (sw *SizeWriter) WriteHeader(status int) {
+	if sw.Status == 0 && status >= 200 {
+		sw.Status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts and writes p.
+func (sw *SizeWriter) Write(p []byte) (int, error) {
+	if sw.Status == 0 {
+		sw.Status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.Bytes += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client.
+func (sw *SizeWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over, for WebSocket upgrades.
+func (sw *SizeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(sw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (sw *SizeWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// recordResponseSize counts a response of n bytes in ws.Metrics: the total
+// in response_bytes and the response in a response_size_* bucket.
+func (ws *WebService) recordResponseSize(n int64) {
+	ws.Metrics.Inc("responses")
+	ws.Metrics.Add("response_bytes", n)
+	for _, b := range responseSizeBuckets {
+		if n <= b.limit {
+			ws.Metrics.Inc(b.name)
+			return
+		}
+	}
+	ws.Metrics.Inc("response_size_gt_1m")
+}
+
+// countedInFlight reports whether w was wrapped by serveMiddleware, so the
+// request is counted in flight.
+func countedInFlight(w http.ResponseWriter) bool {
+	for {
+		if sw, ok := w.(*SizeWriter); ok && sw.inFlight {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
@@ -0,0 +1,49 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseSizeMetrics(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Router.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 2048))
+	})
+	handler := ws.Handler()
+
+	for _, path := range []string{"/healthcheck", "/big"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	tests := []struct {
+		counter string
+		value   int64
+	}{
+		{"responses", 2},
+		{"response_bytes", int64(len(`{"alive": true}`)) + 2048},
+		{"response_size_le_1k", 1},
+		{"response_size_le_10k", 1},
+	}
+	for _, tt := range tests {
+		if got := ws.Metrics.Get(tt.counter); got != tt.value {
+			t.Errorf("%v counter is wrong: got %v want %v", tt.counter, got, tt.value)
+		}
+	}
+}
+
+func TestJsonStatusResponseContentLength(t *testing.T) {
+	ws := new(WebService)
+	rr := httptest.NewRecorder()
+	ws.JsonStatusResponse(rr, "Invalid api_key", http.StatusUnauthorized)
+
+	if got := rr.Header().Get("Content-Length"); got != "18" {
+		t.Errorf("JsonStatusResponse set wrong Content-Length: got %v want %v", got, "18")
+	}
+	if body := rr.Body.String(); body != "\"Invalid api_key\"\n" {
+		t.Errorf("JsonStatusResponse returned unexpected body: got %v want %v", body, "\"Invalid api_key\"\n")
+	}
+}
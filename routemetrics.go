@@ -0,0 +1,197 @@
+package fibre
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// UnmatchedRoute names requests no route matched, unless
+	// ws.NormalizeUnmatched is set.
+	UnmatchedRoute = "unmatched"
+
+	// OverflowRoute names routes past ws.MaxRouteMetrics.
+	OverflowRoute = "overflow"
+
+	// DefaultMaxRouteMetrics bounds the routes counted when
+	// ws.MaxRouteMetrics is zero.
+	DefaultMaxRouteMetrics = 500
+)
+
+// knownMethods are counted by name; others are counted as OTHER.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+type routeKey struct {
+	method, route string
+}
+
+// struct routeCounters caches the counter names for each method and route,
+// so counting a request allocates nothing.
+type routeCounters struct {
+	mu       sync.RWMutex
+	names    map[routeKey][2]string
+	overflow map[string][2]string
+}
+
+func counterNames(method, route string) [2]string {
+	label := " " + method + " " + route
+	return [2]string{"route_requests" + label, "route_errors" + label}
+}
+
+// add returns the counter names for key, adding them unless max routes are
+// already counted, in which case the method's OverflowRoute names are used.
+func (rc *routeCounters) add(key routeKey, max int) [2]string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if names, ok := rc.names[key]; ok {
+		return names
+	}
+	if rc.names == nil {
+		rc.names = make(map[routeKey][2]string)
+		rc.overflow = make(map[string][2]string)
+	}
+	if len(rc.names) < max {
+		rc.names[key] = counterNames(key.method, key.route)
+		return rc.names[key]
+	}
+	if _, ok := rc.overflow[key.method]; !ok {
+		rc.overflow[key.method] = counterNames(key.method, OverflowRoute)
+	}
+	return rc.overflow[key.method]
+}
+
+// NormalizeIDs replaces path segments which look like identifiers (numbers,
+// UUIDs and long hex strings) with {id}, for use as ws.NormalizeUnmatched.
+func NormalizeIDs(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if looksLikeID(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func looksLikeID(s string) bool {
+	if s == "" {
+		return false
+	}
+	digits := true
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return digits || len(s) >= 16
+}
+
+// setRoute records route on every SizeWriter wrapping w.
+func setRoute(w http.ResponseWriter, route string) {
+	for {
+		if sw, ok := w.(*SizeWriter); ok {
+			sw.Route = route
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// routeMiddleware records the template of the matched mux route.
+func routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				setRoute(w, tpl)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// backendRoute records pattern as the route of requests h serves.
+func backendRoute(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRoute(w, pattern)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RouteTemplate returns the template of the route which served r (eg;
+// /page/{page}.html) rather than its raw path, for logs and metrics.
+// Requests no route matched are named by ws.NormalizeUnmatched, or
+// UnmatchedRoute.  It is only complete once the router has run.
+func (ws *WebService) RouteTemplate(w http.ResponseWriter, r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	for {
+		if sw, ok := w.(*SizeWriter); ok && sw.Route != "" {
+			return sw.Route
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	if ws.NormalizeUnmatched != nil {
+		return ws.NormalizeUnmatched(r.URL.Path)
+	}
+	return UnmatchedRoute
+}
+
+func (ws *WebService) maxRouteMetrics() int {
+	if ws.MaxRouteMetrics > 0 {
+		return ws.MaxRouteMetrics
+	}
+	return DefaultMaxRouteMetrics
+}
+
+// recordRoute counts the request in route_requests and, for 5xx responses,
+// route_errors, labelled with its method and route.  Past MaxRouteMetrics
+// distinct routes, new ones are counted as OverflowRoute.
+func (ws *WebService) recordRoute(method, route string, status int) {
+	if ws.Metrics == nil {
+		return
+	}
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+
+	key := routeKey{method, route}
+	rc := &ws.routeCounters
+	rc.mu.RLock()
+	names, ok := rc.names[key]
+	if !ok {
+		names, ok = rc.overflow[method]
+	}
+	rc.mu.RUnlock()
+	if !ok {
+		names = rc.add(key, ws.maxRouteMetrics())
+	}
+
+	ws.Metrics.Inc(names[0])
+	if status >= 500 {
+		ws.Metrics.Inc(names[1])
+	}
+}
@@ -0,0 +1,79 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMetrics(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	ws.Router.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	})
+	handler := ws.Handler()
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail", "/missing/1", "/missing/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/users/3", nil))
+
+	tests := []struct {
+		name string
+		want int64
+	}{
+		{"route_requests GET /users/{id}", 2},
+		{"route_requests OTHER /users/{id}", 1},
+		{"route_requests GET /fail", 1},
+		{"route_errors GET /fail", 1},
+		{"route_requests GET unmatched", 2},
+		{"route_requests GET /users/1", 0},
+	}
+	for _, tt := range tests {
+		if got := ws.Metrics.Get(tt.name); got != tt.want {
+			t.Errorf("%v counted wrong: got %v want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRouteMetricsCardinality(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.NormalizeUnmatched = NormalizeIDs
+	ws.MaxRouteMetrics = 2
+	handler := ws.Handler()
+
+	for _, path := range []string{"/orders/42", "/orders/0f8fad5b-d9cb-469f-a165-70867728950e", "/a", "/b", "/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	tests := []struct {
+		name string
+		want int64
+	}{
+		{"route_requests GET /orders/{id}", 2},
+		{"route_requests GET /a", 1},
+		{"route_requests GET overflow", 2},
+	}
+	for _, tt := range tests {
+		if got := ws.Metrics.Get(tt.name); got != tt.want {
+			t.Errorf("%v counted wrong: got %v want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRouteTemplateBackend(t *testing.T) {
+	ws := NewWebServiceWithRouter("test", ":0", NewServeMuxBackend())
+	var route string
+	ws.Handle("GET", "/items/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route = ws.RouteTemplate(w, r)
+	}))
+	ws.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/7", nil))
+
+	if route != "/items/{id}" {
+		t.Errorf("RouteTemplate returned wrong route: got %v want %v", route, "/items/{id}")
+	}
+	if got := ws.Metrics.Get("route_requests GET /items/{id}"); got != 1 {
+		t.Errorf("backend route counted wrong: got %v want %v", got, 1)
+	}
+}
@@ -0,0 +1,59 @@
+// Package chibackend serves fibre routes with go-chi/chi.
+package chibackend
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	fibre "github.com/lakesite/ls-fibre"
+)
+
+// struct Backend is a fibre.RouterBackend using a chi.Mux.
+type Backend struct {
+	Mux *chi.Mux
+}
+
+// New returns a Backend for fibre.NewWebServiceWithRouter.
+func New() *Backend {
+	return &Backend{Mux: chi.NewRouter()}
+}
+
+// Handle registers h, panicking on patterns chi cannot express.
+func (b *Backend) Handle(method, pattern string, h http.Handler) {
+	keys := make(map[string]string)
+	path, names, err := fibre.TranslatePattern(pattern, func(name string, catchAll bool) string {
+		if catchAll {
+			keys[name] = "*"
+			return "*"
+		}
+		keys[name] = name
+		return "{" + name + "}"
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := make(map[string]string, len(names))
+		for _, name := range names {
+			vars[name] = chi.URLParam(r, keys[name])
+		}
+		h.ServeHTTP(w, fibre.WithVars(r, vars))
+	})
+	if method == "" {
+		b.Mux.Handle(path, handler)
+		return
+	}
+	b.Mux.Method(method, path, handler)
+}
+
+// NotFound sets the handler for requests no route matches.  Requests for a
+// route with another method also go to h, so the fallback router sees them.
+func (b *Backend) NotFound(h http.Handler) {
+	b.Mux.NotFound(h.ServeHTTP)
+	b.Mux.MethodNotAllowed(h.ServeHTTP)
+}
+
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Mux.ServeHTTP(w, r)
+}
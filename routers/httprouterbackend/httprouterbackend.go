@@ -0,0 +1,73 @@
+// Package httprouterbackend serves fibre routes with julienschmidt/httprouter.
+package httprouterbackend
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	fibre "github.com/lakesite/ls-fibre"
+)
+
+// methods are registered for routes added without a method.
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// struct Backend is a fibre.RouterBackend using an httprouter.Router.
+type Backend struct {
+	Router *httprouter.Router
+}
+
+// New returns a Backend for fibre.NewWebServiceWithRouter.  Redirects and
+// automatic OPTIONS and 405 responses are turned off, so requests the
+// routes do not match exactly fall through to fibre's router.
+func New() *Backend {
+	r := httprouter.New()
+	r.RedirectTrailingSlash = false
+	r.RedirectFixedPath = false
+	r.HandleOPTIONS = false
+	r.HandleMethodNotAllowed = false
+	return &Backend{Router: r}
+}
+
+// Handle registers h, panicking on patterns httprouter cannot express or
+// which conflict with an earlier route.
+func (b *Backend) Handle(method, pattern string, h http.Handler) {
+	path, names, err := fibre.TranslatePattern(pattern, func(name string, catchAll bool) string {
+		if catchAll {
+			return "*" + name
+		}
+		return ":" + name
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		vars := make(map[string]string, len(names))
+		for _, name := range names {
+			// catch all parameters keep their leading slash.
+			vars[name] = strings.TrimPrefix(params.ByName(name), "/")
+		}
+		h.ServeHTTP(w, fibre.WithVars(r, vars))
+	})
+	if method != "" {
+		b.Router.Handler(method, path, handler)
+		return
+	}
+	for _, m := range methods {
+		b.Router.Handler(m, path, handler)
+	}
+}
+
+// NotFound sets the handler for requests no route matches.
+func (b *Backend) NotFound(h http.Handler) {
+	b.Router.NotFound = h
+}
+
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Router.ServeHTTP(w, r)
+}
@@ -0,0 +1,48 @@
+package httprouterbackend
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fibre "github.com/lakesite/ls-fibre"
+)
+
+func TestBackend(t *testing.T) {
+	ws := fibre.NewWebServiceWithRouter("test", ":0", New())
+	ws.Handle("GET", "/items/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "item "+fibre.Vars(r)["id"])
+	}))
+	ws.Handle("", "/files/{path:.+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "file "+fibre.Vars(r)["path"])
+	}))
+	ws.Router.HandleFunc("/legacy/{name}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "legacy "+fibre.Vars(r)["name"])
+	})
+	handler := ws.Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/items/42", http.StatusOK, "item 42"},
+		{"GET", "/files/a/b.txt", http.StatusOK, "file a/b.txt"},
+		{"GET", "/healthcheck", http.StatusOK, `{"alive": true}`},
+		{"GET", "/legacy/x", http.StatusOK, "legacy x"},
+		{"POST", "/items/42", http.StatusNotFound, "404 page not found"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v", tt.method, tt.path, status, tt.status)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%v %v returned unexpected body: got %v want %v", tt.method, tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
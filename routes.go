@@ -0,0 +1,203 @@
+package fibre
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// struct RouteDoc documents a route for the route listing and generated
+// OpenAPI specification.
+type RouteDoc struct {
+	Summary     string   `json:"summary,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+}
+
+// struct RouteInfo describes a registered route.
+type RouteInfo struct {
+	Path    string    `json:"path"`
+	Methods []string  `json:"methods,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Doc     *RouteDoc `json:"doc,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// struct RouteConflict describes a route which can never (or only partly)
+// be reached because of an earlier registration.
+type RouteConflict struct {
+	// Kind is "duplicate" for the same pattern registered twice, or
+	// "shadowed" when an earlier, broader route matches first.
+	Kind       string    `json:"kind"`
+	Route      RouteInfo `json:"route"`
+	ShadowedBy RouteInfo `json:"shadowed_by"`
+}
+
+func (c RouteConflict) String() string {
+	return fmt.Sprintf("%s route %s %s, registered earlier as %s %s",
+		c.Kind, methodList(c.Route.Methods), c.Route.Path, methodList(c.ShadowedBy.Methods), c.ShadowedBy.Path)
+}
+
+func methodList(methods []string) string {
+	if len(methods) == 0 {
+		return "*"
+	}
+	return strings.Join(methods, ",")
+}
+
+// Document attaches doc to route, returning the route so registration can
+// be chained:
+//
+//	ws.Document(ws.Router.HandleFunc("/items", list).Methods("GET"), fibre.RouteDoc{
+//		Summary: "List items",
+//		Tags:    []string{"items"},
+//	})
+func (ws *WebService) Document(route *mux.Route, doc RouteDoc) *mux.Route {
+	if ws.docs == nil {
+		ws.docs = make(map[*mux.Route]RouteDoc)
+	}
+	ws.docs[route] = doc
+	return route
+}
+
+// routeDoc returns the documentation attached to route, if any.
+func (ws *WebService) routeDoc(route *mux.Route) *RouteDoc {
+	if doc, ok := ws.docs[route]; ok {
+		return &doc
+	}
+	return nil
+}
+
+// Routes returns the routes added to ws.Backend, then those with handlers
+// registered on ws.Router, in matching order.
+func (ws *WebService) Routes() []RouteInfo {
+	routes := append([]RouteInfo(nil), ws.backendRoutes...)
+	ws.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		info := RouteInfo{Path: tmpl, Name: route.GetName(), Doc: ws.routeDoc(route)}
+		info.Methods, _ = route.GetMethods()
+		sort.Strings(info.Methods)
+		if re, err := route.GetPathRegexp(); err == nil {
+			info.pattern, _ = regexp.Compile(re)
+		}
+		routes = append(routes, info)
+		return nil
+	})
+	return routes
+}
+
+// covers reports whether a route with methods a receives every request with
+// methods b.
+func covers(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, m := range b {
+		found := false
+		for _, n := range a {
+			if m == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func overlaps(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, m := range a {
+		for _, n := range b {
+			if m == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// samplePath returns a concrete path matching the template of route, for
+// testing earlier patterns against it.
+func samplePath(route RouteInfo) (string, bool) {
+	for _, v := range []string{"x", "1", "x-1"} {
+		p := pathVarPattern.ReplaceAllString(route.Path, v)
+		if route.pattern == nil || route.pattern.MatchString(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// CheckRoutes reports routes registered twice for the same methods, and
+// routes shadowed by an earlier, broader pattern (such as a catch-all
+// PathPrefix) that gorilla/mux will always match first.
+func (ws *WebService) CheckRoutes() []RouteConflict {
+	routes := ws.Routes()
+	var conflicts []RouteConflict
+	for j, later := range routes {
+		sample, ok := samplePath(later)
+		for _, earlier := range routes[:j] {
+			if earlier.Path == later.Path {
+				if overlaps(earlier.Methods, later.Methods) {
+					conflicts = append(conflicts, RouteConflict{Kind: "duplicate", Route: later, ShadowedBy: earlier})
+					break
+				}
+				continue
+			}
+			if ok && earlier.pattern != nil && earlier.pattern.MatchString(sample) && covers(earlier.Methods, later.Methods) {
+				conflicts = append(conflicts, RouteConflict{Kind: "shadowed", Route: later, ShadowedBy: earlier})
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// checkRoutes logs route conflicts, returning an error when ws.StrictRoutes
+// is set and any were found.
+func (ws *WebService) checkRoutes() error {
+	conflicts := ws.CheckRoutes()
+	for _, c := range conflicts {
+		fmt.Printf("%v route warning: %v\n", ws.Instance, c)
+	}
+	if ws.StrictRoutes && len(conflicts) > 0 {
+		return fmt.Errorf("fibre: %d conflicting route registrations", len(conflicts))
+	}
+	return nil
+}
+
+// RoutesHandler lists the registered routes and any conflicts as JSON, for
+// the admin router.
+func (ws *WebService) RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Routes    []RouteInfo     `json:"routes"`
+		Conflicts []RouteConflict `json:"conflicts"`
+	}{
+		Routes:    ws.Routes(),
+		Conflicts: ws.CheckRoutes(),
+	}
+	if response.Conflicts == nil {
+		response.Conflicts = []RouteConflict{}
+	}
+	ws.writeJSON(w, response, http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package fibre
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckRoutes(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.Router.HandleFunc("/healthcheck", ws.HealthCheckHandler)
+	ws.Router.HandleFunc("/items/{id}", ws.HealthCheckHandler).Methods("GET")
+	ws.Router.HandleFunc("/items/{id}", ws.HealthCheckHandler).Methods("DELETE")
+	ws.Router.PathPrefix("/api/").HandlerFunc(ws.HealthCheckHandler)
+	ws.Router.HandleFunc("/api/users", ws.HealthCheckHandler)
+
+	conflicts := ws.CheckRoutes()
+	if len(conflicts) != 2 {
+		t.Fatalf("CheckRoutes returned wrong number of conflicts: got %v want %v: %v", len(conflicts), 2, conflicts)
+	}
+
+	if conflicts[0].Kind != "duplicate" || conflicts[0].Route.Path != "/healthcheck" {
+		t.Errorf("CheckRoutes returned unexpected conflict: %v", conflicts[0])
+	}
+
+	if conflicts[1].Kind != "shadowed" || conflicts[1].Route.Path != "/api/users" || conflicts[1].ShadowedBy.Path != "/api/" {
+		t.Errorf("CheckRoutes returned unexpected conflict: %v", conflicts[1])
+	}
+}
+
+func TestCheckRoutesStrict(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	if err := ws.checkRoutes(); err != nil {
+		t.Errorf("checkRoutes returned an error for the default routes: %v", err)
+	}
+
+	ws.StrictRoutes = true
+	ws.Router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	if err := ws.checkRoutes(); err == nil {
+		t.Errorf("checkRoutes did not fail on a duplicate route with StrictRoutes set")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.Document(ws.Router.HandleFunc("/items", ws.HealthCheckHandler).Methods("GET"), RouteDoc{
+		Summary:    "List items",
+		Tags:       []string{"items"},
+		Deprecated: true,
+	})
+
+	var doc *RouteDoc
+	for _, r := range ws.Routes() {
+		if r.Path == "/items" {
+			doc = r.Doc
+		}
+	}
+	if doc == nil || doc.Summary != "List items" {
+		t.Errorf("Routes returned unexpected documentation: %v", doc)
+	}
+
+	spec := ws.OpenAPI(OpenAPIInfo{})
+	op := spec["paths"].(map[string]map[string]interface{})["/items"]["get"].(map[string]interface{})
+	if op["summary"] != "List items" || op["deprecated"] != true {
+		t.Errorf("OpenAPI returned unexpected operation: %v", op)
+	}
+}
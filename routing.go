@@ -0,0 +1,140 @@
+package fibre
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// interface RouterBackend is a router fibre can serve routes added with
+// ws.Handle from, in place of gorilla/mux.  Patterns use gorilla/mux syntax
+// (see TranslatePattern), and requests no route matches go to the NotFound
+// handler.
+type RouterBackend interface {
+	http.Handler
+	Handle(method, pattern string, h http.Handler)
+	NotFound(h http.Handler)
+}
+
+var patternVar = regexp.MustCompile(`^\{([^}:]+)(?::(.*))?\}$`)
+
+// TranslatePattern rewrites a gorilla/mux style pattern for another router,
+// returning it with the names of its variables.  Each {name} segment is
+// replaced by param(name, false), and a final {name:.+} or {name:.*} by
+// param(name, true).  Other regular expressions, and variables sharing a
+// segment with text, are not supported.
+func TranslatePattern(pattern string, param func(name string, catchAll bool) string) (string, []string, error) {
+	segments := strings.Split(pattern, "/")
+	var names []string
+	for i, seg := range segments {
+		m := patternVar.FindStringSubmatch(seg)
+		if m == nil {
+			if strings.ContainsAny(seg, "{}") {
+				return "", nil, fmt.Errorf("fibre: unsupported pattern segment %q in %v", seg, pattern)
+			}
+			continue
+		}
+		catchAll := false
+		switch m[2] {
+		case "":
+		case ".+", ".*":
+			if i != len(segments)-1 {
+				return "", nil, fmt.Errorf("fibre: catch all variable must end pattern %v", pattern)
+			}
+			catchAll = true
+		default:
+			return "", nil, fmt.Errorf("fibre: unsupported regular expression in pattern %v", pattern)
+		}
+		names = append(names, m[1])
+		segments[i] = param(m[1], catchAll)
+	}
+	return strings.Join(segments, "/"), names, nil
+}
+
+// WithVars returns r carrying the path variables matched by a RouterBackend,
+// for Vars.
+func WithVars(r *http.Request, vars map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), varsContextKey, vars))
+}
+
+// Vars returns the path variables for r, from whichever router matched it.
+func Vars(r *http.Request) map[string]string {
+	if vars, ok := r.Context().Value(varsContextKey).(map[string]string); ok {
+		return vars
+	}
+	return mux.Vars(r)
+}
+
+// Handle registers h for method (any method when empty) and pattern on
+// ws.Backend, or ws.Router when no backend is configured.
+func (ws *WebService) Handle(method, pattern string, h http.Handler) {
+	if ws.Backend == nil {
+		route := ws.Router.Handle(pattern, h)
+		if method != "" {
+			route.Methods(method)
+		}
+		return
+	}
+	ws.Backend.Handle(method, pattern, backendRoute(pattern, h))
+	info := RouteInfo{Path: pattern}
+	if method != "" {
+		info.Methods = []string{method}
+	}
+	ws.backendRoutes = append(ws.backendRoutes, info)
+}
+
+// struct ServeMuxBackend routes with net/http's ServeMux patterns.
+type ServeMuxBackend struct {
+	mux      *http.ServeMux
+	notFound http.Handler
+}
+
+// NewServeMuxBackend returns a RouterBackend using http.ServeMux.
+func NewServeMuxBackend() *ServeMuxBackend {
+	b := &ServeMuxBackend{mux: http.NewServeMux(), notFound: http.NotFoundHandler()}
+	b.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		b.notFound.ServeHTTP(w, r)
+	})
+	return b
+}
+
+// Handle registers h, panicking on patterns ServeMux cannot express.
+func (b *ServeMuxBackend) Handle(method, pattern string, h http.Handler) {
+	path, names, err := TranslatePattern(pattern, func(name string, catchAll bool) string {
+		if catchAll {
+			return "{" + name + "...}"
+		}
+		return "{" + name + "}"
+	})
+	if err != nil {
+		panic(err)
+	}
+	// gorilla/mux patterns match exactly, where ServeMux treats a trailing
+	// slash as a prefix.
+	if strings.HasSuffix(path, "/") {
+		path += "{$}"
+	}
+	if method != "" {
+		path = method + " " + path
+	}
+	b.mux.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := make(map[string]string, len(names))
+		for _, name := range names {
+			vars[name] = r.PathValue(name)
+		}
+		h.ServeHTTP(w, WithVars(r, vars))
+	}))
+}
+
+// NotFound sets the handler for requests no pattern matches.
+func (b *ServeMuxBackend) NotFound(h http.Handler) {
+	b.notFound = h
+}
+
+func (b *ServeMuxBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mux.ServeHTTP(w, r)
+}
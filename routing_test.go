@@ -0,0 +1,65 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTranslatePattern(t *testing.T) {
+	param := func(name string, catchAll bool) string {
+		if catchAll {
+			return "*" + name
+		}
+		return ":" + name
+	}
+
+	path, names, err := TranslatePattern("/img/{params}/{path:.+}", param)
+	if err != nil || path != "/img/:params/*path" || len(names) != 2 {
+		t.Errorf("TranslatePattern returned unexpected pattern: got %v, %v, %v", path, names, err)
+	}
+
+	for _, bad := range []string{"/page/{page}.html", "/items/{id:[0-9]+}", "/{rest:.+}/edit"} {
+		if _, _, err := TranslatePattern(bad, param); err == nil {
+			t.Errorf("TranslatePattern accepted %v", bad)
+		}
+	}
+}
+
+func TestServeMuxBackend(t *testing.T) {
+	ws := NewWebServiceWithRouter("test", ":0", NewServeMuxBackend())
+	ws.Handle("GET", "/items/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "item "+Vars(r)["id"])
+	}))
+	ws.Handle("", "/files/{path:.+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "file "+Vars(r)["path"])
+	}))
+	handler := ws.Handler()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/items/42", http.StatusOK, "item 42"},
+		{"/files/a/b.txt", http.StatusOK, "file a/b.txt"},
+		{"/healthcheck", http.StatusOK, `{"alive": true}`},
+		{"/missing", http.StatusNotFound, "404 page not found"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.path, status, tt.status)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%v returned unexpected body: got %v want %v", tt.path, w.Body.String(), tt.body)
+		}
+	}
+
+	if routes := ws.Routes(); routes[0].Path != "/items/{id}" {
+		t.Errorf("Routes did not list the backend routes first: %v", routes[0].Path)
+	}
+}
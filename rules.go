@@ -0,0 +1,247 @@
+package fibre
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateVar matches {name} references in rule templates.
+var templateVar = regexp.MustCompile(`\$?\{(\w+)\}`)
+
+// struct Rule rewrites or redirects the requests matching all of its
+// conditions.  Host is a host name or a *.domain wildcard, and Headers map
+// header names to regular expressions their values must match.  Path is a
+// gorilla/mux style pattern ({name}, or {name:.*} for the rest of the
+// path) and Regex a regular expression, both matched against the whole
+// path.  Rewrite, Redirect and SetQuery values may refer to Path variables
+// as {name}, and to Regex groups as $1 or ${name}.
+//
+// A Redirect answers with Status (302 by default) and ends evaluation; its
+// query is the request's, after SetQuery and DelQuery, unless it has one of
+// its own.  A Rewrite changes the path and query seen by later rules and
+// the router; Last ends evaluation after it.
+type Rule struct {
+	Name    string            `json:"name"`
+	Host    string            `json:"host,omitempty"`
+	Methods []string          `json:"methods,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Regex   string            `json:"regex,omitempty"`
+
+	Rewrite  string            `json:"rewrite,omitempty"`
+	Redirect string            `json:"redirect,omitempty"`
+	Status   int               `json:"status,omitempty"`
+	SetQuery map[string]string `json:"set_query,omitempty"`
+	DelQuery []string          `json:"del_query,omitempty"`
+	Last     bool              `json:"last,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	path     *regexp.Regexp
+	headers  map[string]*regexp.Regexp
+	setQuery map[string]string
+}
+
+// struct Rules is a compiled, ordered set of rules.
+type Rules struct {
+	rules []compiledRule
+}
+
+// patternRegexp compiles a gorilla/mux style pattern to an anchored
+// regular expression with a named group per variable.
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	var groups []string
+	translated, _, err := TranslatePattern(pattern, func(name string, catchAll bool) string {
+		if catchAll {
+			groups = append(groups, "(?P<"+name+">.*)")
+		} else {
+			groups = append(groups, "(?P<"+name+">[^/]+)")
+		}
+		return "\x00" + strconv.Itoa(len(groups)-1) + "\x00"
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the placeholders survive quoting, so only literal text is escaped.
+	expr := regexp.QuoteMeta(translated)
+	for i, g := range groups {
+		expr = strings.Replace(expr, "\x00"+strconv.Itoa(i)+"\x00", g, 1)
+	}
+	return regexp.Compile("^" + expr + "$")
+}
+
+// CompileRules checks and compiles rules, in the order they are evaluated.
+func CompileRules(rules []Rule) (*Rules, error) {
+	rs := &Rules{}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		c := compiledRule{Rule: rule, headers: make(map[string]*regexp.Regexp)}
+
+		var err error
+		switch {
+		case rule.Path != "" && rule.Regex != "":
+			return nil, fmt.Errorf("fibre: rule %v has both a path and a regex", name)
+		case rule.Path != "":
+			c.path, err = patternRegexp(rule.Path)
+		case rule.Regex != "":
+			c.path, err = regexp.Compile(rule.Regex)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fibre: rule %v: %w", name, err)
+		}
+		for h, expr := range rule.Headers {
+			if c.headers[http.CanonicalHeaderKey(h)], err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("fibre: rule %v header %v: %w", name, h, err)
+			}
+		}
+
+		if rule.Rewrite != "" && rule.Redirect != "" {
+			return nil, fmt.Errorf("fibre: rule %v has both a rewrite and a redirect", name)
+		}
+		if rule.Redirect != "" {
+			if c.Status == 0 {
+				c.Status = http.StatusFound
+			}
+			if c.Status < 300 || c.Status > 399 {
+				return nil, fmt.Errorf("fibre: rule %v has redirect status %d", name, c.Status)
+			}
+		}
+		c.Rewrite = templateVar.ReplaceAllString(rule.Rewrite, "$${$1}")
+		c.Redirect = templateVar.ReplaceAllString(rule.Redirect, "$${$1}")
+		c.setQuery = make(map[string]string, len(rule.SetQuery))
+		for k, v := range rule.SetQuery {
+			c.setQuery[k] = templateVar.ReplaceAllString(v, "$${$1}")
+		}
+		rs.rules = append(rs.rules, c)
+	}
+	return rs, nil
+}
+
+// LoadRules reads and compiles a JSON array of rules from path.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("fibre: rules %v: %w", path, err)
+	}
+	return CompileRules(rules)
+}
+
+// match returns the path submatches when c applies to r, or nil.
+func (c *compiledRule) match(r *http.Request) []int {
+	if c.Host != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if suffix, ok := strings.CutPrefix(c.Host, "*."); ok {
+			if !strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix)) {
+				return nil
+			}
+		} else if !strings.EqualFold(host, c.Host) {
+			return nil
+		}
+	}
+	if len(c.Methods) > 0 {
+		found := false
+		for _, m := range c.Methods {
+			found = found || strings.EqualFold(m, r.Method)
+		}
+		if !found {
+			return nil
+		}
+	}
+	for h, re := range c.headers {
+		if !re.MatchString(r.Header.Get(h)) {
+			return nil
+		}
+	}
+	if c.path == nil {
+		return []int{0, len(r.URL.Path)}
+	}
+	return c.path.FindStringSubmatchIndex(r.URL.Path)
+}
+
+func (c *compiledRule) expand(template, path string, m []int) string {
+	if c.path == nil {
+		return template
+	}
+	return string(c.path.ExpandString(nil, template, path, m))
+}
+
+// Apply evaluates the rules against r, rewriting r.URL in place, and
+// returns the location and status of a redirect when a rule makes one.
+func (rs *Rules) Apply(r *http.Request) (string, int) {
+	if rs == nil {
+		return "", 0
+	}
+	for i := range rs.rules {
+		c := &rs.rules[i]
+		path := r.URL.Path
+		m := c.match(r)
+		if m == nil {
+			continue
+		}
+
+		if len(c.setQuery) > 0 || len(c.DelQuery) > 0 {
+			q := r.URL.Query()
+			for _, k := range c.DelQuery {
+				q.Del(k)
+			}
+			for k, v := range c.setQuery {
+				q.Set(k, c.expand(v, path, m))
+			}
+			r.URL.RawQuery = q.Encode()
+		}
+
+		if c.Redirect != "" {
+			location := c.expand(c.Redirect, path, m)
+			if !strings.Contains(location, "?") && r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			return location, c.Status
+		}
+		if c.Rewrite != "" {
+			rewritten, err := url.Parse(c.expand(c.Rewrite, path, m))
+			if err == nil {
+				r.URL.Path, r.URL.RawPath = rewritten.Path, rewritten.RawPath
+				if rewritten.RawQuery != "" {
+					r.URL.RawQuery = rewritten.RawQuery
+				}
+			}
+		}
+		if c.Last {
+			break
+		}
+	}
+	return "", 0
+}
+
+// RulesMiddleware returns middleware applying rs before the wrapped handler,
+// answering redirects itself.
+func (ws *WebService) RulesMiddleware(rs *Rules) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if location, status := rs.Apply(r); location != "" {
+				ws.Metrics.Inc("rule_redirects")
+				http.Redirect(w, r, location, status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
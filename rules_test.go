@@ -0,0 +1,100 @@
+package fibre
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := CompileRules([]Rule{
+		{Name: "legacy", Path: "/v1/users/{id}", Redirect: "/v2/users/{id}", Status: http.StatusMovedPermanently},
+		{Name: "blog", Host: "*.example.com", Regex: `^/blog/(\d+)$`, Redirect: "https://blog.example.com/posts/$1"},
+		{Name: "mobile", Headers: map[string]string{"User-Agent": "Mobile"}, Path: "/", Rewrite: "/m/"},
+		{Name: "static", Path: "/assets/{file:.*}", Rewrite: "/static/{file}", DelQuery: []string{"cb"}, SetQuery: map[string]string{"v": "2"}, Last: true},
+		{Name: "never", Path: "/static/{file:.*}", Rewrite: "/gone"},
+	})
+	if err != nil {
+		t.Fatalf("CompileRules returned an error: %v", err)
+	}
+
+	ws := NewWebService("test", ":0")
+	ws.Rules = rules
+	ws.Router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	})
+	handler := ws.Handler()
+
+	tests := []struct {
+		url, agent string
+		status     int
+		location   string
+		body       string
+	}{
+		{"http://example.com/v1/users/42?x=1", "", http.StatusMovedPermanently, "/v2/users/42?x=1", ""},
+		{"http://www.example.com/blog/7", "", http.StatusFound, "https://blog.example.com/posts/7", ""},
+		{"http://example.org/blog/7", "", http.StatusOK, "", "/blog/7"},
+		{"http://example.com/", "Mobile Safari", http.StatusOK, "", "/m/"},
+		{"http://example.com/assets/css/site.css?cb=1", "", http.StatusOK, "", "/static/css/site.css?v=2"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		req.Header.Set("User-Agent", tt.agent)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v returned wrong status code: got %v want %v", tt.url, status, tt.status)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%v returned wrong location: got %v want %v", tt.url, got, tt.location)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%v was rewritten wrongly: got %v want %v", tt.url, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRulesErrors(t *testing.T) {
+	bad := []Rule{
+		{Path: "/a", Regex: "^/a$"},
+		{Regex: "("},
+		{Path: "/a", Rewrite: "/b", Redirect: "/c"},
+		{Path: "/a", Redirect: "/b", Status: http.StatusOK},
+		{Headers: map[string]string{"X-A": "["}},
+	}
+	for _, rule := range bad {
+		if _, err := CompileRules([]Rule{rule}); err == nil {
+			t.Errorf("CompileRules accepted an invalid rule: %+v", rule)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`[{"name": "old", "path": "/old", "redirect": "/new", "status": 308}]`), 0644)
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("LoadRules returned an error: %v", err)
+	}
+	if location, status := rules.Apply(httptest.NewRequest("GET", "/old", nil)); location != "/new" || status != http.StatusPermanentRedirect {
+		t.Errorf("loaded rule returned wrong redirect: got %v %v want %v %v", location, status, "/new", http.StatusPermanentRedirect)
+	}
+}
+
+func TestProxyRewrite(t *testing.T) {
+	var path string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer backend.Close()
+
+	rewrite, _ := CompileRules([]Rule{{Path: "/api/v2/{rest:.*}", Rewrite: "/api/v3/{rest}"}})
+	ws := NewWebService("test", ":0")
+	ws.SetupProxy(ProxyConfig{Path: "/api/", Host: backend.URL, Rewrite: rewrite}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v2/items/1", nil))
+
+	if path != "/api/v3/items/1" {
+		t.Errorf("proxy rewrite sent wrong path upstream: got %v want %v", path, "/api/v3/items/1")
+	}
+}
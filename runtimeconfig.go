@@ -0,0 +1,314 @@
+package fibre
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// struct RuntimeTimeouts are the effective timeouts of the instance;
+// "disabled" when turned off.
+type RuntimeTimeouts struct {
+	Read     string `json:"read"`
+	Write    string `json:"write"`
+	Shutdown string `json:"shutdown"`
+	Drain    string `json:"drain"`
+	SelfTest string `json:"self_test"`
+}
+
+// struct RuntimeLimits are the effective request limits of the instance;
+// zero is unlimited.
+type RuntimeLimits struct {
+	MaxHeaders       int `json:"max_headers"`
+	MaxHeaderBytes   int `json:"max_header_bytes"`
+	MaxRouteMetrics  int `json:"max_route_metrics"`
+	RequestTraceSize int `json:"request_trace_size"`
+}
+
+// struct RateLimitConfig is the rate of a RateLimiter, or of a Tier.
+type RateLimitConfig struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst"`
+}
+
+// struct TierLimits are the limits of a Tier.
+type TierLimits struct {
+	Name         string `json:"name"`
+	PerMinute    int    `json:"per_minute"`
+	Burst        int    `json:"burst"`
+	Concurrency  int    `json:"concurrency"`
+	DailyQuota   int64  `json:"daily_quota"`
+	MonthlyQuota int64  `json:"monthly_quota"`
+}
+
+// struct ProxyRoute describes a proxy without its credentials.
+type ProxyRoute struct {
+	Path                string `json:"path"`
+	Host                string `json:"host"`
+	Trace               bool   `json:"trace"`
+	PreserveHost        bool   `json:"preserve_host"`
+	TrustForwarded      bool   `json:"trust_forwarded"`
+	MaxRequestBytes     int64  `json:"max_request_bytes"`
+	MaxResponseBytes    int64  `json:"max_response_bytes"`
+	MaxIdleConnsPerHost int    `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int    `json:"max_conns_per_host"`
+	IdleConnTimeout     string `json:"idle_conn_timeout"`
+	Auth                string `json:"auth,omitempty"`
+	CacheTTL            string `json:"cache_ttl,omitempty"`
+	StaleIfError        string `json:"stale_if_error,omitempty"`
+	Compression         bool   `json:"compression"`
+	Rewrite             bool   `json:"rewrite"`
+	Transforms          bool   `json:"transforms"`
+}
+
+// struct RuntimeConfig is the effective configuration of a running
+// instance, reported by ConfigHandler.
+type RuntimeConfig struct {
+	Instance    string            `json:"instance"`
+	Address     string            `json:"address"`
+	LogLevel    LogLevel          `json:"log_level"`
+	Maintenance MaintenanceMode   `json:"maintenance"`
+	Timeouts    RuntimeTimeouts   `json:"timeouts"`
+	Middleware  map[string]bool   `json:"middleware"`
+	Limits      RuntimeLimits     `json:"limits"`
+	RateLimits  []RateLimitConfig `json:"rate_limits"`
+	Tiers       []TierLimits      `json:"tiers"`
+	Proxies     []ProxyRoute      `json:"proxies"`
+	Upstreams   []UpstreamHealth  `json:"upstreams"`
+}
+
+// struct RuntimeChange is the subset of the configuration which may be
+// changed while serving.  RateLimit applies to every RateLimitMiddleware
+// limiter, and Tiers change the rates of the named key store tiers.
+type RuntimeChange struct {
+	LogLevel    *LogLevel                  `json:"log_level,omitempty"`
+	Maintenance *MaintenanceMode           `json:"maintenance,omitempty"`
+	RateLimit   *RateLimitConfig           `json:"rate_limit,omitempty"`
+	Tiers       map[string]RateLimitConfig `json:"tiers,omitempty"`
+}
+
+func durationOrDisabled(d time.Duration) string {
+	if d <= 0 {
+		return "disabled"
+	}
+	return d.String()
+}
+
+// proxyAuthKind names the kind of credentials pa sends, never the
+// credentials themselves.
+func proxyAuthKind(pa *ProxyAuth) string {
+	switch {
+	case pa == nil:
+		return ""
+	case pa.Token != nil:
+		return "token"
+	case pa.Username != "":
+		return "basic"
+	}
+	return "bearer"
+}
+
+// RuntimeConfig returns the effective configuration of the instance.
+func (ws *WebService) RuntimeConfig() RuntimeConfig {
+	rc := RuntimeConfig{
+		Instance:    ws.Instance,
+		Address:     ws.Address,
+		LogLevel:    ws.LogLevel(),
+		Maintenance: ws.Maintenance(),
+		Timeouts: RuntimeTimeouts{
+			Read:     durationOrDisabled(timeoutOrDefault(ws.ReadTimeout)),
+			Write:    durationOrDisabled(timeoutOrDefault(ws.WriteTimeout)),
+			Shutdown: orDefault(ws.ShutdownTimeout, DefaultShutdownTimeout).String(),
+			Drain:    durationOrDisabled(ws.DrainDelay),
+			SelfTest: orDefault(ws.SelfTestTimeout, DefaultSelfTestTimeout).String(),
+		},
+		Middleware: map[string]bool{
+			"acme":                 ws.ACME != nil,
+			"api_keys":             ws.Keys != nil,
+			"audit_log":            ws.Audit != nil,
+			"dev_mode":             ws.DevMode,
+			"hardening":            ws.Hardening != nil,
+			"method_policy":        ws.MethodPolicy != nil,
+			"minify":               ws.Minify != nil,
+			"page_cache":           ws.PageCache != nil,
+			"request_trace":        ws.Requests != nil,
+			"rules":                ws.Rules != nil,
+			"skip_aborted_renders": ws.SkipAbortedRenders,
+			"strict_json":          ws.StrictJSON,
+			"strict_routes":        ws.StrictRoutes,
+		},
+		Limits: RuntimeLimits{
+			MaxRouteMetrics: orDefault(ws.MaxRouteMetrics, DefaultMaxRouteMetrics),
+		},
+		RateLimits: []RateLimitConfig{},
+		Tiers:      []TierLimits{},
+		Proxies:    []ProxyRoute{},
+		Upstreams:  ws.Upstreams(),
+	}
+
+	if ws.Hardening != nil {
+		rc.Limits.MaxHeaders, rc.Limits.MaxHeaderBytes = ws.Hardening.MaxHeaders, ws.Hardening.MaxHeaderBytes
+	}
+	if ws.Requests != nil {
+		rc.Limits.RequestTraceSize = ws.Requests.size
+	}
+
+	ws.rateLimitersMu.Lock()
+	for _, rl := range ws.rateLimiters {
+		perMinute, burst := rl.Limits()
+		rc.RateLimits = append(rc.RateLimits, RateLimitConfig{PerMinute: perMinute, Burst: burst})
+	}
+	ws.rateLimitersMu.Unlock()
+	if ws.Keys != nil {
+		for _, t := range ws.Keys.Tiers() {
+			rc.Tiers = append(rc.Tiers, TierLimits{Name: t.Name, PerMinute: t.RequestsPerMinute, Burst: t.Burst, Concurrency: t.Concurrency, DailyQuota: t.DailyQuota, MonthlyQuota: t.MonthlyQuota})
+		}
+	}
+
+	for _, pc := range ws.proxies {
+		transport := DefaultTransportConfig
+		if pc.Transport != nil {
+			transport = pc.Transport.withDefaults()
+		} else if ws.ProxyTransport != nil {
+			transport = ws.ProxyTransport.withDefaults()
+		}
+		route := ProxyRoute{
+			Path:                pc.Path,
+			Host:                pc.Host,
+			Trace:               pc.Trace,
+			PreserveHost:        pc.PreserveHost,
+			TrustForwarded:      pc.TrustForwarded,
+			MaxRequestBytes:     pc.MaxRequestBytes,
+			MaxResponseBytes:    pc.MaxResponseBytes,
+			MaxIdleConnsPerHost: transport.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     transport.MaxConnsPerHost,
+			IdleConnTimeout:     transport.IdleConnTimeout.String(),
+			Auth:                proxyAuthKind(pc.Auth),
+			Compression:         pc.Compression != nil,
+			Rewrite:             pc.Rewrite != nil || pc.Override.Match != "",
+			Transforms:          pc.RequestTransform != nil || pc.ResponseTransform != nil,
+		}
+		if pc.Cache != nil {
+			route.CacheTTL, route.StaleIfError = pc.Cache.TTL.String(), pc.Cache.StaleIfError.String()
+		}
+		rc.Proxies = append(rc.Proxies, route)
+	}
+	return rc
+}
+
+// ChangeRuntimeConfig applies c, recording each setting changed in
+// ws.Audit as done by actor from r's client.  Nothing is changed unless
+// all of c is valid.
+func (ws *WebService) ChangeRuntimeConfig(r *http.Request, actor string, c RuntimeChange) error {
+	if c.RateLimit != nil {
+		if c.RateLimit.PerMinute < 0 || c.RateLimit.Burst < 0 {
+			return errors.New("rate_limit must not be negative")
+		}
+		ws.rateLimitersMu.Lock()
+		limiters := len(ws.rateLimiters)
+		ws.rateLimitersMu.Unlock()
+		if limiters == 0 {
+			return errors.New("no rate limiter to change")
+		}
+	}
+	tiers := make([]string, 0, len(c.Tiers))
+	for name, limits := range c.Tiers {
+		if ws.Keys == nil {
+			return errors.New("no key store to change tiers of")
+		}
+		if _, ok := ws.Keys.Tier(name); !ok {
+			return fmt.Errorf("unknown tier %q", name)
+		}
+		if limits.PerMinute < 0 || limits.Burst < 0 {
+			return fmt.Errorf("tier %q limits must not be negative", name)
+		}
+		tiers = append(tiers, name)
+	}
+	sort.Strings(tiers)
+
+	change := func(setting string, from, to interface{}) {
+		ws.audit(r, AuditEvent{Action: "config.change", Actor: actor, Target: setting, Success: true, Detail: map[string]string{
+			"from": fmt.Sprint(from),
+			"to":   fmt.Sprint(to),
+		}})
+	}
+	if c.LogLevel != nil {
+		change("log_level", ws.LogLevel(), *c.LogLevel)
+		ws.SetLogLevel(*c.LogLevel)
+	}
+	if c.Maintenance != nil {
+		change("maintenance", ws.Maintenance(), *c.Maintenance)
+		ws.SetMaintenance(*c.Maintenance)
+	}
+	if c.RateLimit != nil {
+		ws.rateLimitersMu.Lock()
+		for _, rl := range ws.rateLimiters {
+			perMinute, burst := rl.Limits()
+			change("rate_limit", RateLimitConfig{perMinute, burst}, *c.RateLimit)
+			rl.SetLimits(c.RateLimit.PerMinute, c.RateLimit.Burst)
+		}
+		ws.rateLimitersMu.Unlock()
+	}
+	for _, name := range tiers {
+		t, _ := ws.Keys.Tier(name)
+		change("tier "+name, RateLimitConfig{t.RequestsPerMinute, t.Burst}, c.Tiers[name])
+		t.RequestsPerMinute, t.Burst = c.Tiers[name].PerMinute, c.Tiers[name].Burst
+		ws.Keys.SetTier(t)
+	}
+	return nil
+}
+
+// adminActor names who made an admin request, from the X-Admin-User header
+// (the admin key itself is shared).
+func adminActor(r *http.Request) string {
+	if user := r.Header.Get("X-Admin-User"); user != "" {
+		return user
+	}
+	return "admin"
+}
+
+// ConfigHandler reports RuntimeConfig as JSON for GET, and applies a JSON
+// RuntimeChange for PATCH, for the admin router.  Changes are audited as
+// made by the X-Admin-User header, and settings outside RuntimeChange are
+// refused.
+func (ws *WebService) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPatch:
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+		dec.DisallowUnknownFields()
+		var c RuntimeChange
+		if err := dec.Decode(&c); err != nil {
+			ws.JsonStatusResponse(w, "Invalid configuration change: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ws.ChangeRuntimeConfig(r, adminActor(r), c); err != nil {
+			ws.audit(r, AuditEvent{Action: "config.change", Actor: adminActor(r), Detail: map[string]string{"error": err.Error()}})
+			ws.JsonStatusResponse(w, "Invalid configuration change: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PATCH")
+		ws.MethodNotAllowedHandler(w, r)
+		return
+	}
+	ws.writeJSON(w, ws.RuntimeConfig(), http.StatusOK)
+}
+
+// String formats a rate limit for the audit log.
+func (rl RateLimitConfig) String() string {
+	return strconv.Itoa(rl.PerMinute) + "/min burst " + strconv.Itoa(rl.Burst)
+}
+
+// String formats a maintenance mode for the audit log.
+func (m MaintenanceMode) String() string {
+	if !m.Enabled {
+		return "off"
+	}
+	return "on: " + strconv.Quote(m.Message)
+}
@@ -0,0 +1,115 @@
+package fibre
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func patchConfig(ws *WebService, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PATCH", "/admin/config", strings.NewReader(body))
+	r.Header.Set("X-Admin-User", "ops")
+	w := httptest.NewRecorder()
+	ws.ConfigHandler(w, r)
+	return w
+}
+
+func TestConfigHandler(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Audit = NewAuditLog(nil)
+	ws.Keys = NewKeyStore()
+	ws.Keys.SetTier(Tier{Name: "free", RequestsPerMinute: 60, Burst: 10})
+	rl := NewRateLimiter(120, 20)
+	ws.RateLimitMiddleware(rl)
+	ws.RateLimitMiddleware(rl)
+	ws.Proxy([]ProxyConfig{{Path: "/api/", Host: "http://127.0.0.1:1", Auth: &ProxyAuth{Bearer: "secret"}}})
+
+	w := httptest.NewRecorder()
+	ws.ConfigHandler(w, httptest.NewRequest("GET", "/admin/config", nil))
+	if status := w.Code; status != http.StatusOK {
+		t.Fatalf("ConfigHandler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("ConfigHandler reported proxy credentials: %s", w.Body.String())
+	}
+	var rc RuntimeConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &rc); err != nil {
+		t.Fatalf("ConfigHandler returned invalid JSON: %v", err)
+	}
+	if rc.LogLevel != LogInfo || rc.Maintenance.Enabled || len(rc.RateLimits) != 1 || len(rc.Tiers) != 1 || len(rc.Proxies) != 1 || rc.Proxies[0].Auth != "bearer" {
+		t.Errorf("ConfigHandler returned wrong config: got %+v", rc)
+	}
+
+	w = patchConfig(ws, `{"log_level": "warn", "maintenance": {"enabled": true, "message": "Upgrading"}, "rate_limit": {"per_minute": 30, "burst": 5}, "tiers": {"free": {"per_minute": 10, "burst": 2}}}`)
+	if status := w.Code; status != http.StatusOK {
+		t.Fatalf("ConfigHandler returned wrong status code: got %v want %v: %s", status, http.StatusOK, w.Body.String())
+	}
+	if ws.LogLevel() != LogWarn || !ws.Maintenance().Enabled || ws.Maintenance().Message != "Upgrading" {
+		t.Errorf("ConfigHandler did not change log level and maintenance: got %v %+v", ws.LogLevel(), ws.Maintenance())
+	}
+	if perMinute, burst := rl.Limits(); perMinute != 30 || burst != 5 {
+		t.Errorf("ConfigHandler did not change rate limit: got %v/%v want 30/5", perMinute, burst)
+	}
+	if tier, _ := ws.Keys.Tier("free"); tier.RequestsPerMinute != 10 || tier.Burst != 2 {
+		t.Errorf("ConfigHandler did not change tier: got %+v", tier)
+	}
+
+	events := ws.Audit.Recent()
+	if len(events) != 4 {
+		t.Fatalf("ConfigHandler audited wrong number of changes: got %v want %v", len(events), 4)
+	}
+	if e := events[0]; e.Action != "config.change" || e.Actor != "ops" || e.Target != "log_level" || e.Detail["from"] != "info" || e.Detail["to"] != "warn" || !e.Success {
+		t.Errorf("ConfigHandler audited wrong change: got %+v", e)
+	}
+}
+
+func TestConfigHandlerInvalid(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Audit = NewAuditLog(nil)
+	ws.Keys = NewKeyStore()
+
+	tests := []string{
+		`{"read_timeout": "5s"}`,
+		`{"rate_limit": {"per_minute": 30}}`,
+		`{"log_level": "loud"}`,
+		`{"rate_limit": {"per_minute": -1}}`,
+		`{"log_level": "debug", "tiers": {"gold": {"per_minute": 10}}}`,
+	}
+	for _, body := range tests {
+		if w := patchConfig(ws, body); w.Code != http.StatusBadRequest {
+			t.Errorf("ConfigHandler returned wrong status code for %s: got %v want %v", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if ws.LogLevel() != LogInfo {
+		t.Errorf("ConfigHandler applied part of an invalid change: got log level %v", ws.LogLevel())
+	}
+	if events := ws.Audit.Recent(); len(events) != 3 || events[0].Success {
+		t.Errorf("ConfigHandler audited wrong failures: got %+v", events)
+	}
+
+	w := httptest.NewRecorder()
+	ws.ConfigHandler(w, httptest.NewRequest("POST", "/admin/config", nil))
+	if status := w.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("ConfigHandler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestUpstreams(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	for i := 0; i < upstreamUnhealthyAfter; i++ {
+		ws.recordUpstream("b.example", "502 Bad Gateway")
+	}
+	ws.recordUpstream("a.example", "")
+
+	upstreams := ws.Upstreams()
+	if len(upstreams) != 2 || upstreams[0].Host != "a.example" || !upstreams[0].Healthy || upstreams[1].Healthy || upstreams[1].Failures != upstreamUnhealthyAfter {
+		t.Fatalf("Upstreams returned wrong health: got %+v", upstreams)
+	}
+
+	ws.recordUpstream("b.example", "")
+	if u := ws.Upstreams()[1]; !u.Healthy || u.ConsecutiveFailures != 0 || u.LastError == "" {
+		t.Errorf("Upstreams returned wrong health after recovery: got %+v", u)
+	}
+}
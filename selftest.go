@@ -0,0 +1,89 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultSelfTestTimeout bounds SelfTest when ws.SelfTestTimeout is unset.
+const DefaultSelfTestTimeout = 30 * time.Second
+
+// SelfTest boots the instance on an ephemeral loopback port, running the
+// startup hooks first and the shutdown hooks once done, runs the registered
+// health checks, requests /healthcheck and each of ws.SmokeRoutes, and
+// returns an error describing every failure.  It is meant for container
+// health checks and pre-deploy gates, in place of serving, eg;
+//
+//	if *selftest {
+//		if err := ws.SelfTest(); err != nil {
+//			log.Fatal(err)
+//		}
+//		return
+//	}
+func (ws *WebService) SelfTest() error {
+	timeout := ws.SelfTestTimeout
+	if timeout == 0 {
+		timeout = DefaultSelfTestTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := ws.Start(ctx); err != nil {
+		return fmt.Errorf("%v self test failed: %w", ws.Instance, err)
+	}
+	defer ws.stop(ctx)
+
+	var failures []error
+	if err := ws.checkRoutes(); err != nil {
+		failures = append(failures, err)
+	}
+
+	results, _ := ws.RunHealthChecks(ctx)
+	for _, r := range results {
+		switch {
+		case r.OK:
+		case r.Critical:
+			failures = append(failures, fmt.Errorf("health check %s: %s", r.Name, r.Error))
+		default:
+			fmt.Printf("%v self test warning: health check %s: %s\n", ws.Instance, r.Name, r.Error)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: ws.Handler()}
+	go server.Serve(ln)
+	defer server.Close()
+
+	base := "http://" + ln.Addr().String()
+	for _, path := range append([]string{"/healthcheck"}, ws.SmokeRoutes...) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("smoke route %s: %v", path, err))
+			continue
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("smoke route %s: %v", path, err))
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			failures = append(failures, fmt.Errorf("smoke route %s: %s", path, resp.Status))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%v self test failed: %w", ws.Instance, errors.Join(failures...))
+	}
+	fmt.Printf("%v self test passed.\n", ws.Instance)
+	return nil
+}
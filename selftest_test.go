@@ -0,0 +1,71 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.AddHealthCheck("noop", true, func(ctx context.Context) error { return nil })
+	ws.AddHealthCheck("optional", false, func(ctx context.Context) error { return errors.New("degraded") })
+	ws.SmokeRoutes = []string{"/", "/page/index.html"}
+
+	if err := ws.SelfTest(); err != nil {
+		t.Errorf("SelfTest returned unexpected error: %v", err)
+	}
+
+	ws.AddHealthCheck("database", true, func(ctx context.Context) error { return errors.New("unreachable") })
+	ws.SmokeRoutes = append(ws.SmokeRoutes, "/page/missing.html")
+
+	err := ws.SelfTest()
+	if err == nil {
+		t.Fatalf("SelfTest passed with a failing critical check and route")
+	}
+	if !strings.Contains(err.Error(), "database") || !strings.Contains(err.Error(), "/page/missing.html") {
+		t.Errorf("SelfTest returned unexpected error: %v", err)
+	}
+}
+
+func TestSelfTestHooks(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	started, stopped := false, false
+	ws.OnStartup(Hook{Name: "database", Run: func(context.Context) error {
+		started = true
+		return nil
+	}})
+	ws.OnShutdown(Hook{Name: "database", Run: func(context.Context) error {
+		stopped = true
+		return nil
+	}})
+	ws.AddHealthCheck("database", true, func(ctx context.Context) error {
+		if !started {
+			return errors.New("not started")
+		}
+		return nil
+	})
+
+	if err := ws.SelfTest(); err != nil {
+		t.Errorf("SelfTest returned unexpected error: %v", err)
+	}
+	if !stopped {
+		t.Errorf("SelfTest did not run the shutdown hooks")
+	}
+
+	ws = NewWebService("test", "127.0.0.1:7999")
+	stopped = false
+	ws.OnStartup(Hook{Name: "database", Run: func(context.Context) error { return nil }})
+	ws.OnStartup(Hook{Name: "broken", Priority: 1, Run: func(context.Context) error { return errors.New("failed") }})
+	ws.OnShutdown(Hook{Name: "database", Run: func(context.Context) error {
+		stopped = true
+		return nil
+	}})
+	if err := ws.SelfTest(); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("SelfTest returned unexpected error for a failing startup hook: %v", err)
+	}
+	if !stopped {
+		t.Errorf("SelfTest did not stop the started hooks after a failing startup hook")
+	}
+}
@@ -0,0 +1,100 @@
+package fibre
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// maxPooledBuffer is the largest JSON buffer returned to the pool, so one
+// large response does not pin its memory.
+const maxPooledBuffer = 64 << 10
+
+// jsonContentType is shared by JSON responses, saving a header allocation
+// each.
+var jsonContentType = []string{"application/json"}
+
+type jsonBuffer struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonBuffers = sync.Pool{
+	New: func() interface{} {
+		jb := new(jsonBuffer)
+		jb.enc = json.NewEncoder(&jb.buf)
+		return jb
+	},
+}
+
+func getJSONBuffer() *jsonBuffer {
+	return jsonBuffers.Get().(*jsonBuffer)
+}
+
+func putJSONBuffer(jb *jsonBuffer) {
+	if jb.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	jb.buf.Reset()
+	jsonBuffers.Put(jb)
+}
+
+var sizeWriters = sync.Pool{
+	New: func() interface{} {
+		return new(SizeWriter)
+	},
+}
+
+// serveMiddleware is the outermost built in stage: it counts the request in
+// flight, records the size of the response and its route (and traces it in
+// ws.Requests), and answers handlers which panic with InternalErrorHandler.
+// Requests made by GenerateLoad are served without being counted.  Its
+// writer is pooled, so it must not be used once the handler returns.
+func (ws *WebService) serveMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted := r.Context().Value(loadContextKey) == nil
+		if counted {
+			ws.inFlight.Add(1)
+		}
+		sw := sizeWriters.Get().(*SizeWriter)
+		*sw = SizeWriter{ResponseWriter: w, inFlight: counted}
+
+		var start time.Time
+		var body *countingBody
+		if counted && ws.Requests != nil {
+			start = time.Now()
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingBody{ReadCloser: r.Body}
+				r.Body = body
+			}
+		}
+
+		defer func() {
+			err := recover()
+			if err != nil && err != http.ErrAbortHandler {
+				ws.Metrics.Inc("panics")
+				log.Printf("%v panic serving %v: %v\n%s", ws.Instance, r.URL.Path, err, debug.Stack())
+				ws.InternalErrorHandler(sw, r)
+			}
+			if counted {
+				ws.inFlight.Add(-1)
+				ws.recordResponseSize(sw.Bytes)
+				route := ws.RouteTemplate(sw, r)
+				ws.recordRoute(r.Method, route, sw.Status)
+				if ws.Requests != nil {
+					ws.traceRequest(r, sw, route, start, body, err)
+				}
+			}
+			*sw = SizeWriter{}
+			sizeWriters.Put(sw)
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,65 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// discardWriter is a ResponseWriter which allocates nothing itself.
+type discardWriter struct {
+	header http.Header
+}
+
+func (dw *discardWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (dw *discardWriter) WriteHeader(status int) {}
+
+func TestServeMiddlewareAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable under the race detector")
+	}
+	ws := NewWebService("test", ":0")
+	body := []byte("ok")
+	handler := ws.serveMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	w := &discardWriter{header: make(http.Header)}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if n := testing.AllocsPerRun(100, func() { handler.ServeHTTP(w, r) }); n > 0 {
+		t.Errorf("serveMiddleware allocated per request: got %v want %v", n, 0)
+	}
+}
+
+func TestWriteJSONAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable under the race detector")
+	}
+	ws := new(WebService)
+	w := &discardWriter{header: make(http.Header)}
+	v := &struct {
+		OK bool `json:"ok"`
+	}{true}
+
+	if n := testing.AllocsPerRun(100, func() { ws.writeJSON(w, v, http.StatusOK) }); n > 1 {
+		t.Errorf("writeJSON allocated too much per response: got %v want at most %v", n, 1)
+	}
+}
+
+func BenchmarkHealthCheck(b *testing.B) {
+	handler := NewWebService("test", ":0").Handler()
+	w := &discardWriter{header: make(http.Header)}
+	r := httptest.NewRequest("GET", "/healthcheck", nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,71 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout bounds how long RunWebServer waits for requests to
+// finish after SIGINT or SIGTERM.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// setServer records the server RunWebServer is running, for Shutdown.
+func (ws *WebService) setServer(server *http.Server) {
+	ws.serverMu.Lock()
+	defer ws.serverMu.Unlock()
+	ws.server = server
+	ws.started = time.Now()
+}
+
+// Shutdown marks the instance as draining and, after ws.DrainDelay, sends
+// the shutdown notice to streaming clients, so they reconnect once the
+// instance has left rotation rather than into the drain.  It then gracefully
+// stops the server started by RunWebServer, waiting for in flight requests
+// until ctx is done, and finally runs the shutdown hooks.
+func (ws *WebService) Shutdown(ctx context.Context) error {
+	ws.draining.Store(true)
+	if ws.DrainDelay > 0 {
+		select {
+		case <-time.After(ws.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	ws.broadcastShutdown()
+
+	ws.serverMu.Lock()
+	server := ws.server
+	ws.serverMu.Unlock()
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	return errors.Join(err, ws.stop(ctx))
+}
+
+// shutdownOnSignal calls Shutdown on SIGINT or SIGTERM, closing done once
+// it returns.
+func (ws *WebService) shutdownOnSignal(done chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+
+	timeout := ws.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	fmt.Printf("%v received %v, shutting down.\n", ws.Instance, sig)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		log.Printf("%v shutdown: %v", ws.Instance, err)
+	}
+	close(done)
+}
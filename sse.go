@@ -0,0 +1,209 @@
+package fibre
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultSSEKeepAlive is the interval between SSE comment pings.
+const DefaultSSEKeepAlive = 30 * time.Second
+
+// sseNoticeTimeout bounds how long Shutdown waits for slow clients to take
+// the shutdown notice.
+const sseNoticeTimeout = time.Second
+
+// WebSocketCloseServiceRestart is the WebSocket close code (1012) to send
+// with a ShutdownNotice, telling clients to reconnect.
+const WebSocketCloseServiceRestart = 1012
+
+// struct ShutdownNotice is broadcast to streaming clients when shutdown
+// begins.  Retry is the reconnect delay suggested to clients.
+type ShutdownNotice struct {
+	Event   string
+	Message string
+	Retry   time.Duration
+}
+
+// DefaultShutdownNotice is used when ws.ShutdownNotice is unset.
+var DefaultShutdownNotice = ShutdownNotice{
+	Event:   "restarting",
+	Message: "server restarting",
+	Retry:   2 * time.Second,
+}
+
+type sseEvent struct {
+	event string
+	data  string
+	retry time.Duration
+}
+
+// struct SSEHub fans events out to connected Server-Sent Events clients.
+type SSEHub struct {
+	KeepAlive time.Duration
+
+	mu      sync.Mutex
+	clients map[chan sseEvent]struct{}
+	closed  bool
+}
+
+// NewSSEHub returns a hub with no clients.
+func NewSSEHub() *SSEHub {
+	return &SSEHub{KeepAlive: DefaultSSEKeepAlive, clients: make(map[chan sseEvent]struct{})}
+}
+
+// Count returns the number of connected clients.
+func (h *SSEHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Broadcast sends an event to every connected client, dropping it for
+// clients too slow to keep up.
+func (h *SSEHub) Broadcast(event, data string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		select {
+		case c <- sseEvent{event: event, data: data}:
+		default:
+		}
+	}
+}
+
+// Shutdown sends notice to every client, with a reconnect hint, then ends
+// their streams.  Clients with a full buffer are given sseNoticeTimeout to
+// catch up.  New clients are refused with 503 afterwards.
+func (h *SSEHub) Shutdown(notice ShutdownNotice) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	clients := make([]chan sseEvent, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+		delete(h.clients, c)
+	}
+	h.mu.Unlock()
+
+	// the deadline is shared by every client and stays done once reached.
+	ctx, cancel := context.WithTimeout(context.Background(), sseNoticeTimeout)
+	defer cancel()
+	e := sseEvent{event: notice.Event, data: notice.Message, retry: notice.Retry}
+	for _, c := range clients {
+		select {
+		case c <- e:
+		case <-ctx.Done():
+		}
+		close(c)
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e sseEvent) error {
+	var b strings.Builder
+	if e.retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.retry.Milliseconds())
+	}
+	if e.event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.event)
+	}
+	for _, line := range strings.Split(e.data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// ServeHTTP streams events to the client until it disconnects or the hub
+// shuts down.
+func (h *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := make(chan sseEvent, 16)
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		if _, ok := h.clients[c]; ok {
+			delete(h.clients, c)
+		}
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+
+	keepAlive := h.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultSSEKeepAlive
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			http.NewResponseController(w).Flush()
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// SSE registers a new hub at path, exempt from the server timeouts, which
+// will be sent the shutdown notice when the server shuts down.
+func (ws *WebService) SSE(path string) (*SSEHub, *mux.Route) {
+	hub := NewSSEHub()
+	ws.sseHubs = append(ws.sseHubs, hub)
+	return hub, ws.HandleStream(path, hub.ServeHTTP)
+}
+
+// OnShutdownNotice registers f to be called with the shutdown notice when
+// shutdown begins, so WebSocket and other long lived connections can send
+// a close frame (WebSocketCloseServiceRestart) with a reconnect hint.
+func (ws *WebService) OnShutdownNotice(f func(ShutdownNotice)) {
+	ws.noticeHooks = append(ws.noticeHooks, f)
+}
+
+// broadcastShutdown sends the shutdown notice to every stream.
+func (ws *WebService) broadcastShutdown() {
+	notice := DefaultShutdownNotice
+	if ws.ShutdownNotice != nil {
+		notice = *ws.ShutdownNotice
+	}
+	for _, hub := range ws.sseHubs {
+		hub.Shutdown(notice)
+	}
+	for _, f := range ws.noticeHooks {
+		f(notice)
+	}
+}
@@ -0,0 +1,133 @@
+package fibre
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSSEShutdownNotice(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	ws.ShutdownNotice = &ShutdownNotice{Event: "restarting", Message: "deploying", Retry: 1500 * time.Millisecond}
+	hub, _ := ws.SSE("/events")
+
+	var notice ShutdownNotice
+	ws.OnShutdownNotice(func(n ShutdownNotice) {
+		notice = n
+	})
+
+	server := httptest.NewServer(ws.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	for hub.Count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Broadcast("message", "hello")
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	got := strings.Join(lines, "\n")
+	want := "event: message\ndata: hello\n\nretry: 1500\nevent: restarting\ndata: deploying\n"
+	if got != want {
+		t.Errorf("SSE stream returned unexpected events: got %q want %q", got, want)
+	}
+
+	if notice.Message != "deploying" {
+		t.Errorf("OnShutdownNotice hook got wrong message: got %v want %v", notice.Message, "deploying")
+	}
+}
+
+func TestSSERefusedAfterShutdown(t *testing.T) {
+	hub := NewSSEHub()
+	hub.Shutdown(DefaultShutdownNotice)
+
+	req, err := http.NewRequest("GET", "/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	hub.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("SSEHub returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSSEShutdownSlowClient(t *testing.T) {
+	hub := NewSSEHub()
+	c := make(chan sseEvent, 1)
+	c <- sseEvent{event: "message"}
+	hub.clients[c] = struct{}{}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-c
+	}()
+	hub.Shutdown(DefaultShutdownNotice)
+	if e, ok := <-c; !ok || e.event != DefaultShutdownNotice.Event {
+		t.Errorf("SSEHub dropped the shutdown notice for a slow client: got %+v", e)
+	}
+}
+
+func TestSSEShutdownStalledClients(t *testing.T) {
+	hub := NewSSEHub()
+	clients := make([]chan sseEvent, 3)
+	for i := range clients {
+		clients[i] = make(chan sseEvent, 1)
+		clients[i] <- sseEvent{event: "message"}
+		hub.clients[clients[i]] = struct{}{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		hub.Shutdown(DefaultShutdownNotice)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * sseNoticeTimeout):
+		t.Fatalf("SSEHub Shutdown blocked on stalled clients")
+	}
+	for i, c := range clients {
+		<-c
+		if _, ok := <-c; ok {
+			t.Errorf("SSEHub did not end the stream of stalled client %d", i)
+		}
+	}
+}
+
+func TestShutdownNoticeAfterDrain(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.DrainDelay = 50 * time.Millisecond
+	start := time.Now()
+	var noticed time.Duration
+	ws.OnShutdownNotice(func(ShutdownNotice) {
+		noticed = time.Since(start)
+	})
+
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if noticed < ws.DrainDelay {
+		t.Errorf("Shutdown sent the notice during the drain: after %v want at least %v", noticed, ws.DrainDelay)
+	}
+}
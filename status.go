@@ -0,0 +1,65 @@
+package fibre
+
+import (
+	"net/http"
+	"time"
+)
+
+// Lifecycle states reported by StatusHandler.
+const (
+	StateRunning     = "running"
+	StateMaintenance = "maintenance"
+	StateDraining    = "draining"
+)
+
+// struct ServiceStatus reports the lifecycle of an instance, for deploy
+// tooling deciding when to cut traffic over.
+type ServiceStatus struct {
+	State         string  `json:"state"`
+	InFlight      int64   `json:"in_flight"`
+	Streams       int64   `json:"streams"`
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// Draining reports whether shutdown has begun.
+func (ws *WebService) Draining() bool {
+	return ws.draining.Load()
+}
+
+// Status returns the current lifecycle state, the requests in flight, the
+// active streams registered with HandleStream, and the time since
+// RunWebServer started.
+func (ws *WebService) Status() ServiceStatus {
+	status := ServiceStatus{
+		State:    StateRunning,
+		InFlight: ws.inFlight.Load(),
+		Streams:  ws.streams.Load(),
+	}
+	if ws.Draining() {
+		status.State = StateDraining
+	} else if ws.Maintenance().Enabled {
+		status.State = StateMaintenance
+	}
+
+	ws.serverMu.Lock()
+	started := ws.started
+	ws.serverMu.Unlock()
+	if !started.IsZero() {
+		uptime := time.Since(started).Round(time.Second)
+		status.Uptime = uptime.String()
+		status.UptimeSeconds = uptime.Seconds()
+	}
+	return status
+}
+
+// StatusHandler reports Status as JSON, leaving the status request itself
+// out of the in flight count, for the admin router.
+func (ws *WebService) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	status := ws.Status()
+	if countedInFlight(w) && status.InFlight > 0 {
+		status.InFlight--
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	ws.writeJSON(w, status, http.StatusOK)
+}
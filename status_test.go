@@ -0,0 +1,63 @@
+package fibre
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStatusHandler(t *testing.T) {
+	ws := new(WebService)
+	ws.Router = mux.NewRouter()
+	ws.AdminKey = "admin"
+	ws.Admin().HandleFunc("/status", ws.StatusHandler)
+
+	release := make(chan struct{})
+	ws.HandleStream("/events", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	handler := ws.Handler()
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+	defer close(release)
+	for ws.Status().Streams == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	status := getStatus(t, handler)
+	if status.State != StateRunning {
+		t.Errorf("StatusHandler returned wrong state: got %v want %v", status.State, StateRunning)
+	}
+	if status.InFlight != 1 {
+		t.Errorf("StatusHandler returned wrong in flight count: got %v want %v", status.InFlight, 1)
+	}
+	if status.Streams != 1 {
+		t.Errorf("StatusHandler returned wrong stream count: got %v want %v", status.Streams, 1)
+	}
+
+	ws.Shutdown(context.Background())
+	status = getStatus(t, handler)
+	if status.State != StateDraining {
+		t.Errorf("StatusHandler returned wrong state after Shutdown: got %v want %v", status.State, StateDraining)
+	}
+}
+
+func getStatus(t *testing.T, handler http.Handler) ServiceStatus {
+	req := httptest.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("admin_key", "admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("StatusHandler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	var status ServiceStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
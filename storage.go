@@ -0,0 +1,238 @@
+package fibre
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrObjectNotFound is returned by Storage implementations for missing keys.
+var ErrObjectNotFound = errors.New("fibre: object not found")
+
+// struct Object is a stored file returned by Storage.Get.  The caller must
+// close Body.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Storage abstracts where uploaded files live, so handlers work unchanged
+// against local disk or object storage.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// struct LocalStorage stores objects as files under Root.  Signed URLs point
+// at BaseURL (where DownloadHandler is mounted) and are only required by
+// DownloadHandler when Secret is set.
+type LocalStorage struct {
+	Root    string
+	BaseURL string
+	Secret  []byte
+}
+
+// Put writes r to key under Root.
+func (ls *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := containedPath(ls.Root, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Get opens key under Root.
+func (ls *LocalStorage) Get(ctx context.Context, key string) (*Object, error) {
+	name, err := containedPath(ls.Root, key)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, ErrObjectNotFound
+	}
+	return &Object{
+		Body:        f,
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+	}, nil
+}
+
+// Delete removes key under Root.
+func (ls *LocalStorage) Delete(ctx context.Context, key string) error {
+	name, err := containedPath(ls.Root, key)
+	if err != nil {
+		return ErrObjectNotFound
+	}
+	if err := os.Remove(name); err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (ls *LocalStorage) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, ls.Secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedURL returns a BaseURL link to key valid for expires.
+func (ls *LocalStorage) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u := ls.BaseURL + "/" + strings.Join(segments, "/")
+	if len(ls.Secret) == 0 {
+		return u, nil
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	return u + "?expires=" + exp + "&s=" + ls.signature(key, exp), nil
+}
+
+// VerifyURL reports whether q carries a valid, unexpired signature for key.
+// Without a Secret every request is valid.
+func (ls *LocalStorage) VerifyURL(key string, q url.Values) bool {
+	if len(ls.Secret) == 0 {
+		return true
+	}
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("s")), []byte(ls.signature(key, q.Get("expires"))))
+}
+
+// SaveUpload stores the multipart file in field under key (or the uploaded
+// file name when key is empty) in ws.Storage, returning the key used.
+func (ws *WebService) SaveUpload(r *http.Request, field string, key string) (string, error) {
+	if ws.Storage == nil {
+		return "", errors.New("fibre: no storage configured")
+	}
+	f, header, err := r.FormFile(field)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if key == "" {
+		key = path.Base(filepath.ToSlash(header.Filename))
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	return key, ws.Storage.Put(r.Context(), key, f, contentType)
+}
+
+// inlineTypes are the content types DownloadHandler lets browsers display;
+// anything else, which could run script on the site's origin, is sent as an
+// attachment.
+var inlineTypes = map[string]bool{
+	"application/pdf": true,
+	"audio/mpeg":      true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"text/plain":      true,
+	"video/mp4":       true,
+}
+
+// DownloadHandler streams the object named by the {key} route variable from
+// ws.Storage.  Objects are sent with nosniff, and as attachments unless
+// their type is one of inlineTypes.
+func (ws *WebService) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	key := Vars(r)["key"]
+	if ws.Storage == nil || key == "" {
+		ws.NotFoundHandler(w, r)
+		return
+	}
+	if v, ok := ws.Storage.(interface {
+		VerifyURL(string, url.Values) bool
+	}); ok && !v.VerifyURL(key, r.URL.Query()) {
+		if ws.StrictJSON {
+			ws.jsonError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `403 forbidden`)
+		return
+	}
+
+	obj, err := ws.Storage.Get(r.Context(), key)
+	if err != nil {
+		ws.NotFoundHandler(w, r)
+		return
+	}
+	defer obj.Body.Close()
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if mediaType, _, _ := mime.ParseMediaType(obj.ContentType); !inlineTypes[mediaType] {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+	}
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+	if rs, ok := obj.Body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), obj.ModTime, rs)
+		return
+	}
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+	if !obj.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, obj.Body)
+}
+
+// Downloads registers DownloadHandler under prefix.
+func (ws *WebService) Downloads(prefix string) *mux.Route {
+	return ws.Router.HandleFunc(prefix+"/{key:.+}", ws.DownloadHandler)
+}
@@ -0,0 +1,42 @@
+package fibre
+
+import (
+	"net/http"
+)
+
+// struct ErrorEnvelope is the body of every error response in StrictJSON
+// mode.
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// struct ErrorBody describes an error in an ErrorEnvelope.
+type ErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// jsonError writes message in an ErrorEnvelope with the given status.
+func (ws *WebService) jsonError(w http.ResponseWriter, message string, status int) {
+	ws.writeJSON(w, ErrorEnvelope{Error: ErrorBody{Status: status, Message: message}}, status)
+}
+
+// textError writes message with status as plain text, or as an
+// ErrorEnvelope in StrictJSON mode.
+func (ws *WebService) textError(w http.ResponseWriter, message string, status int) {
+	if ws.StrictJSON {
+		ws.jsonError(w, message, status)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// MethodNotAllowedHandler provides a default method not allowed handler for
+// the instance.
+func (ws *WebService) MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	if ws.StrictJSON {
+		ws.jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,73 @@
+package fibre
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStrictJSON(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.StrictJSON = true
+	ws.Router.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	ws.Router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := ws.Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/missing", http.StatusNotFound},
+		{"POST", "/items", http.StatusMethodNotAllowed},
+		{"GET", "/panic", http.StatusInternalServerError},
+		{"GET", "/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if status := w.Code; status != tt.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v", tt.method, tt.path, status, tt.status)
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%v %v returned unexpected Content-Type header: got %v want %v", tt.method, tt.path, w.Header().Get("Content-Type"), "application/json")
+		}
+		var envelope ErrorEnvelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Status != tt.status {
+			t.Errorf("%v %v returned unexpected body: %v", tt.method, tt.path, w.Body.String())
+		}
+	}
+}
+
+func TestJsonStatusResponseStrictJSON(t *testing.T) {
+	ws := new(WebService)
+	ws.StrictJSON = true
+	w := httptest.NewRecorder()
+	ws.JsonStatusResponse(w, "Invalid api_key", http.StatusUnauthorized)
+
+	expected := "{\"error\":{\"status\":401,\"message\":\"Invalid api_key\"}}\n"
+	if w.Body.String() != expected {
+		t.Errorf("JsonStatusResponse returned unexpected body: got %v want %v", w.Body.String(), expected)
+	}
+}
+
+func TestHandlerRecoversPanics(t *testing.T) {
+	ws := NewWebService("test", ":0")
+	ws.Router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	ws.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+
+	if status := w.Code; status != http.StatusInternalServerError {
+		t.Errorf("Handler returned wrong status code for a panic: got %v want %v", status, http.StatusInternalServerError)
+	}
+	if ws.Metrics.Get("panics") != 1 {
+		t.Errorf("Handler did not count the panic")
+	}
+}
@@ -0,0 +1,101 @@
+package fibre
+
+import (
+	"bufio"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// templateErrorPattern finds the file and line in text/template and
+// html/template parse and execution errors.
+var templateErrorPattern = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// sourceContext is the number of lines shown either side of the line in
+// error on the diagnostic page.
+const sourceContext = 3
+
+type sourceLine struct {
+	Number int
+	Text   string
+	Error  bool
+}
+
+type templateDiagnostic struct {
+	Page   string
+	Error  string
+	File   string
+	Line   int
+	Source []sourceLine
+}
+
+var diagnosticTemplate = template.Must(template.New("diagnostic").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Template error: {{.Page}}</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em; }
+    pre { background: #f6f6f6; padding: 1em; }
+    .error { background: #fdd; }
+  </style>
+</head>
+<body>
+  <h1>Template error rendering {{.Page}}</h1>
+  <p><strong>{{.Error}}</strong></p>
+  {{if .File}}<p>{{.File}}, line {{.Line}}</p>{{end}}
+  {{if .Source}}<pre>{{range .Source}}<span{{if .Error}} class="error"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>{{end}}
+</body>
+</html>
+`))
+
+// sourceExcerpt returns the lines of file around line.
+func sourceExcerpt(file string, line int) []sourceLine {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []sourceLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan() && n <= line+sourceContext; n++ {
+		if n >= line-sourceContext {
+			lines = append(lines, sourceLine{Number: n, Text: scanner.Text(), Error: n == line})
+		}
+	}
+	return lines
+}
+
+// templateError responds to a failure parsing or executing the template
+// files for page: a diagnostic page in DevMode, otherwise
+// InternalErrorHandler.
+func (ws *WebService) templateError(w http.ResponseWriter, r *http.Request, page string, files []string, err error) {
+	log.Printf("%v template error rendering %v: %v", ws.Instance, page, err)
+	ws.Metrics.Inc("template_errors")
+	if !ws.DevMode || ws.StrictJSON {
+		ws.InternalErrorHandler(w, r)
+		return
+	}
+
+	d := templateDiagnostic{Page: page, Error: err.Error()}
+	if m := templateErrorPattern.FindStringSubmatch(d.Error); m != nil {
+		d.Line, _ = strconv.Atoi(m[2])
+		for _, f := range files {
+			if filepath.Base(f) == m[1] {
+				d.File = f
+				d.Source = sourceExcerpt(f, d.Line)
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	diagnosticTemplate.Execute(w, d)
+}
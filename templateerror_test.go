@@ -0,0 +1,61 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func renderBrokenPage(ws *WebService) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/page/broken.html", nil)
+	req = mux.SetURLVars(req, map[string]string{"page": "broken"})
+	w := httptest.NewRecorder()
+	ws.PageHandler(w, req)
+	return w
+}
+
+func TestPageHandlerTemplateError(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+
+	w := renderBrokenPage(ws)
+	if status := w.Code; status != http.StatusInternalServerError {
+		t.Errorf("PageHandler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	if body := w.Body.String(); body != "500 internal server error" {
+		t.Errorf("PageHandler returned unexpected body: %v", body)
+	}
+}
+
+func TestPageHandlerTemplateDiagnostics(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+	ws.DevMode = true
+
+	w := renderBrokenPage(ws)
+	if status := w.Code; status != http.StatusInternalServerError {
+		t.Errorf("PageHandler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"web/test/page/broken.html, line 3", "Missing", `class="error"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PageHandler diagnostic page is missing %q: %v", want, body)
+		}
+	}
+}
+
+func TestPageHandlerMissingPage(t *testing.T) {
+	ws := new(WebService)
+	ws.Instance = "test"
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/page/nope.html", nil), map[string]string{"page": "nope"})
+	w := httptest.NewRecorder()
+	ws.PageHandler(w, req)
+
+	if status := w.Code; status != http.StatusNotFound {
+		t.Errorf("PageHandler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,104 @@
+package fibre
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// struct ProxySpan records one upstream call made by the proxy, in W3C trace
+// context terms.
+type ProxySpan struct {
+	TraceID   string
+	SpanID    string
+	ParentID  string
+	RequestID string
+	Method    string
+	URL       string
+	Status    int
+	Start     time.Time
+	Duration  time.Duration
+	Error     error
+}
+
+// newID returns n random bytes, hex encoded.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseTraceparent splits a version 00 traceparent header.
+func parseTraceparent(h string) (traceID, parentID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2] + parts[3]); err != nil {
+		return "", "", "", false
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+// ensureRequestID gives req an X-Request-ID when the client sent none.
+func ensureRequestID(req *http.Request) string {
+	id := req.Header.Get("X-Request-ID")
+	if id == "" {
+		id = newID(16)
+		req.Header.Set("X-Request-ID", id)
+	}
+	return id
+}
+
+// tracingTransport starts a client span for each upstream call, passing it
+// on in the traceparent header.  tracestate and baggage are forwarded
+// untouched.
+type tracingTransport struct {
+	ws   *WebService
+	next http.RoundTripper
+}
+
+func (tt *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := ProxySpan{
+		SpanID:    newID(8),
+		RequestID: req.Header.Get("X-Request-ID"),
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Start:     time.Now(),
+	}
+	flags := "01"
+	req = req.Clone(req.Context())
+	if traceID, parentID, f, ok := parseTraceparent(req.Header.Get("Traceparent")); ok {
+		span.TraceID, span.ParentID, flags = traceID, parentID, f
+	} else {
+		// a new trace; any tracestate belonged to an invalid parent.
+		span.TraceID = newID(16)
+		req.Header.Del("Tracestate")
+	}
+	req.Header.Set("Traceparent", "00-"+span.TraceID+"-"+span.SpanID+"-"+flags)
+
+	resp, err := tt.next.RoundTrip(req)
+	span.Duration = time.Since(span.Start)
+	span.Error = err
+	if resp != nil {
+		span.Status = resp.StatusCode
+	}
+	tt.ws.recordSpan(span)
+	return resp, err
+}
+
+// recordSpan passes span to ws.SpanRecorder, or logs it.
+func (ws *WebService) recordSpan(span ProxySpan) {
+	if ws.SpanRecorder != nil {
+		ws.SpanRecorder(span)
+		return
+	}
+	log.Printf("proxy span trace=%s span=%s parent=%s request=%s %s %s status=%d duration=%s",
+		span.TraceID, span.SpanID, span.ParentID, span.RequestID, span.Method, span.URL, span.Status, span.Duration)
+}
@@ -0,0 +1,67 @@
+package fibre
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyTraceContext(t *testing.T) {
+	var upstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	ws := new(WebService)
+	var spans []ProxySpan
+	ws.SpanRecorder = func(s ProxySpan) { spans = append(spans, s) }
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Trace: true})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=1")
+	req.Header.Set("Baggage", "user=alice")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if len(spans) != 1 {
+		t.Fatalf("SetupProxy recorded wrong number of spans: got %v want %v", len(spans), 1)
+	}
+	span := spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentID != "00f067aa0ba902b7" || span.Status != http.StatusOK {
+		t.Errorf("SetupProxy recorded unexpected span: %+v", span)
+	}
+
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanID + "-01"
+	if got := upstream.Get("Traceparent"); got != expected {
+		t.Errorf("SetupProxy sent unexpected traceparent: got %v want %v", got, expected)
+	}
+	if upstream.Get("Tracestate") != "vendor=1" || upstream.Get("Baggage") != "user=alice" {
+		t.Errorf("SetupProxy did not forward tracestate and baggage: %v", upstream)
+	}
+	if upstream.Get("X-Request-ID") == "" || upstream.Get("X-Request-ID") != span.RequestID {
+		t.Errorf("SetupProxy did not send a request id: %v", upstream)
+	}
+}
+
+func TestProxyNewTrace(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	ws := new(WebService)
+	ws.SpanRecorder = func(ProxySpan) {}
+	proxy := ws.SetupProxy(ProxyConfig{Path: "/", Host: backend.URL, Trace: true})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Traceparent", "garbage")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, _, _, ok := parseTraceparent(traceparent); !ok || !strings.HasPrefix(traceparent, "00-") {
+		t.Errorf("SetupProxy did not start a new trace: got %v", traceparent)
+	}
+}
@@ -0,0 +1,87 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// upstreamUnhealthyAfter is the number of consecutive failures after which
+// an upstream is reported unhealthy.
+const upstreamUnhealthyAfter = 3
+
+// struct UpstreamHealth reports the calls proxies have made to an upstream
+// host.  A call fails on a connection error or a 502, 503 or 504, and the
+// upstream is unhealthy after three failures in a row.
+type UpstreamHealth struct {
+	Host                string    `json:"host"`
+	Healthy             bool      `json:"healthy"`
+	Requests            int64     `json:"requests"`
+	Failures            int64     `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// healthTransport records the outcome of each upstream call.
+type healthTransport struct {
+	ws   *WebService
+	host string
+	next http.RoundTripper
+}
+
+func (ht *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := ht.next.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		// the client went away; this says nothing of the upstream.
+	case err != nil:
+		ht.ws.recordUpstream(ht.host, err.Error())
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		ht.ws.recordUpstream(ht.host, resp.Status)
+	default:
+		ht.ws.recordUpstream(ht.host, "")
+	}
+	return resp, err
+}
+
+// recordUpstream records a call to host, failed when failure is not empty.
+func (ws *WebService) recordUpstream(host, failure string) {
+	ws.upstreamsMu.Lock()
+	defer ws.upstreamsMu.Unlock()
+	if ws.upstreams == nil {
+		ws.upstreams = make(map[string]*UpstreamHealth)
+	}
+	u, ok := ws.upstreams[host]
+	if !ok {
+		u = &UpstreamHealth{Host: host}
+		ws.upstreams[host] = u
+	}
+	u.Requests++
+	if failure == "" {
+		u.ConsecutiveFailures = 0
+		u.LastSuccess = time.Now()
+	} else {
+		u.Failures++
+		u.ConsecutiveFailures++
+		u.LastFailure = time.Now()
+		u.LastError = failure
+	}
+	u.Healthy = u.ConsecutiveFailures < upstreamUnhealthyAfter
+}
+
+// Upstreams returns the health of each upstream host proxies have called,
+// ordered by host.
+func (ws *WebService) Upstreams() []UpstreamHealth {
+	ws.upstreamsMu.Lock()
+	defer ws.upstreamsMu.Unlock()
+	upstreams := make([]UpstreamHealth, 0, len(ws.upstreams))
+	for _, u := range ws.upstreams {
+		upstreams = append(upstreams, *u)
+	}
+	sort.Slice(upstreams, func(i, j int) bool { return upstreams[i].Host < upstreams[j].Host })
+	return upstreams
+}
@@ -0,0 +1,233 @@
+package fibre
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// struct ConfigError lists every problem Validate found.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("fibre: %d configuration problems:\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// validator collects problems.
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// dir reports a problem unless path is a directory.
+func (v *validator) dir(what, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		v.addf("%v: %v", what, err)
+	} else if !fi.IsDir() {
+		v.addf("%v: %v is not a directory", what, path)
+	}
+}
+
+// upstream reports a problem unless raw is an absolute http or https URL.
+func (v *validator) upstream(what, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		v.addf("%v: %v", what, err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		v.addf("%v: %q is not an http or https URL", what, raw)
+	} else if u.Host == "" {
+		v.addf("%v: %q has no host", what, raw)
+	}
+}
+
+func (v *validator) transport(what string, tc *TransportConfig) {
+	if tc == nil {
+		return
+	}
+	if tc.MaxIdleConnsPerHost < 0 || tc.MaxConnsPerHost < 0 || tc.IdleConnTimeout < 0 {
+		v.addf("%v: negative transport limit", what)
+	}
+}
+
+// validMethod matches an HTTP method token.
+func validMethod(m string) bool {
+	if m == "" {
+		return false
+	}
+	for _, c := range m {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the instance's configuration without binding sockets:
+// the listen address, proxy upstreams and transports, templates, ACME and
+// storage directories, the method policy, hooks, cache warming targets,
+// and route conflicts when StrictRoutes is set.  It returns a *ConfigError
+// listing every problem found, or nil.
+func (ws *WebService) Validate() error {
+	v := &validator{}
+
+	if host, port, err := net.SplitHostPort(ws.Address); err != nil {
+		v.addf("address: %v", err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		v.addf("address: invalid port %q for host %q", port, host)
+	}
+
+	v.transport("proxy transport", ws.ProxyTransport)
+	for _, pc := range ws.proxies {
+		what := "proxy " + pc.Path
+		if !strings.HasPrefix(pc.Path, "/") {
+			v.addf("%v: path must begin with /", what)
+		}
+		v.upstream(what, pc.Host)
+		if (pc.Override.Match == "") != (pc.Override.Path == "") {
+			v.addf("%v: override needs both Match and Path", what)
+		}
+		if pc.MaxRequestBytes < 0 || pc.MaxResponseBytes < 0 {
+			v.addf("%v: negative body limit", what)
+		}
+		v.transport(what, pc.Transport)
+		if pc.Cache != nil && (pc.Cache.TTL < 0 || pc.Cache.StaleIfError < 0 || pc.Cache.MaxBytes < 0 || pc.Cache.MaxEntries < 0) {
+			v.addf("%v: negative cache limit", what)
+		}
+		if pc.Auth != nil {
+			if err := pc.Auth.check(); err != nil {
+				v.addf("%v: %v", what, err)
+			}
+		}
+	}
+
+	if ws.ACME != nil {
+		if ws.ACME.Dir == "" && ws.ACME.Upstream == "" {
+			v.addf("acme: needs a Dir or an Upstream")
+		}
+		if ws.ACME.Dir != "" {
+			v.dir("acme dir", ws.ACME.Dir)
+		}
+		if ws.ACME.Upstream != "" {
+			v.upstream("acme upstream", ws.ACME.Upstream)
+		}
+	}
+
+	ws.validateTemplates(v)
+	ws.preflightMu.Lock()
+	preflight := ws.preflight
+	ws.preflightMu.Unlock()
+	if preflight != nil {
+		for _, p := range ws.RunPreflight(*preflight).Problems {
+			v.addf("preflight: %v: %v", p.File, p.Error)
+		}
+	}
+
+	if ls, ok := ws.Storage.(*LocalStorage); ok {
+		v.dir("storage root", ls.Root)
+	}
+
+	if mp := ws.MethodPolicy; mp != nil {
+		for _, m := range mp.Deny {
+			if !validMethod(m) {
+				v.addf("method policy: invalid method %q in Deny", m)
+			}
+		}
+		prefixes := make([]string, 0, len(mp.Groups))
+		for prefix := range mp.Groups {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			methods := mp.Groups[prefix]
+			if !strings.HasPrefix(prefix, "/") {
+				v.addf("method policy: group %q must begin with /", prefix)
+			}
+			if len(methods) == 0 {
+				v.addf("method policy: group %q allows no methods", prefix)
+			}
+			for _, m := range methods {
+				if !validMethod(m) {
+					v.addf("method policy: invalid method %q in group %q", m, prefix)
+				}
+			}
+		}
+	}
+
+	if cw := ws.warming; cw != nil {
+		if cw.Interval < 0 {
+			v.addf("cache warming: negative interval")
+		}
+		for _, name := range cw.Pages {
+			if _, err := os.Stat("web/" + ws.Instance + "/page/" + name + ".html"); err != nil {
+				v.addf("cache warming: %v", err)
+			}
+		}
+		for _, u := range cw.URLs {
+			if !strings.HasPrefix(u, "/") {
+				v.addf("cache warming: URL %q must be a path beginning with /", u)
+			}
+		}
+	}
+
+	for _, hooks := range [][]Hook{ws.startupHooks, ws.shutdownHooks} {
+		for _, h := range hooks {
+			if h.Run == nil {
+				v.addf("hook %q: no Run func", h.Name)
+			}
+		}
+	}
+
+	if ws.StrictRoutes {
+		for _, c := range ws.CheckRoutes() {
+			v.addf("route: %v", c)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ConfigError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateTemplates parses the base template with each page, when the
+// instance has pages to render.
+func (ws *WebService) validateTemplates(v *validator) {
+	if ws.StrictJSON {
+		return
+	}
+	root := "web/" + ws.Instance
+	pages, _ := filepath.Glob(root + "/page/*.html")
+	for _, name := range ws.PageAllowlist {
+		if _, err := os.Stat(root + "/page/" + name + ".html"); err != nil {
+			v.addf("page allowlist: %v", err)
+		}
+	}
+	if len(pages) == 0 {
+		return
+	}
+
+	base := root + "/templates/base.html"
+	if _, err := os.Stat(base); err != nil {
+		v.addf("templates: %v", err)
+		return
+	}
+	for _, page := range pages {
+		if _, err := template.ParseFiles(base, page); err != nil {
+			v.addf("templates: %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package fibre
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:8080")
+	ws.Proxy([]ProxyConfig{{Path: "/api/", Host: "http://backend:8080"}})
+	if err := ws.Validate(); err != nil {
+		t.Fatalf("Validate returned an error for a valid configuration: %v", err)
+	}
+
+	ws = NewWebService("test", "127.0.0.1:http")
+	ws.Proxy([]ProxyConfig{
+		{Path: "/api/", Host: "backend:8080"},
+		{Path: "/old/", Host: "http://backend", Override: ProxyOverride{Match: "/old/"}},
+	})
+	ws.ACME = &ACMEConfig{Dir: "web/missing"}
+	ws.Storage = &LocalStorage{Root: "fibre.go"}
+	ws.MethodPolicy = &MethodPolicy{Deny: []string{"trace"}, Groups: map[string][]string{"/api/": nil}}
+	ws.OnStartup(Hook{Name: "database"})
+	ws.PageAllowlist = []string{"index", "missing"}
+
+	err := ws.Validate()
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Validate returned wrong error: got %v want *ConfigError", err)
+	}
+	want := []string{
+		`address: invalid port "http" for host "127.0.0.1"`,
+		`proxy /api/: "backend:8080" is not an http or https URL`,
+		`proxy /old/: override needs both Match and Path`,
+		`acme dir: stat web/missing: no such file or directory`,
+		`page allowlist: stat web/test/page/missing.html: no such file or directory`,
+		`storage root: fibre.go is not a directory`,
+		`method policy: invalid method "trace" in Deny`,
+		`method policy: group "/api/" allows no methods`,
+		`hook "database": no Run func`,
+	}
+	if !reflect.DeepEqual(ce.Problems, want) {
+		t.Errorf("Validate returned wrong problems: got %q want %q", ce.Problems, want)
+	}
+
+	if err := ws.Start(context.Background()); err == nil {
+		t.Errorf("Start ran a hook with no Run func")
+	}
+}
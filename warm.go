@@ -0,0 +1,149 @@
+package fibre
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultWarmInterval is how often EnableCacheWarming warms the caches
+// when CacheWarming.Interval is zero.
+const DefaultWarmInterval = 5 * time.Minute
+
+// struct CacheWarming lists what to keep warm: Pages by name (rendered into
+// ws.PageCache) and URLs by path (fetched through the proxies serving them,
+// into their ProxyCache).  Requests are served in process by ws.Handler(),
+// carrying Header, so it can hold an api_key or the headers PageVariant
+// looks at.
+type CacheWarming struct {
+	Pages    []string
+	URLs     []string
+	Interval time.Duration
+	Header   http.Header
+}
+
+// struct WarmReport is the outcome of one WarmCaches pass.
+type WarmReport struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Warmed   int           `json:"warmed"`
+	Failures []string      `json:"failures"`
+}
+
+// targets returns the paths cw warms.
+func (cw CacheWarming) targets() []string {
+	paths := make([]string, 0, len(cw.Pages)+len(cw.URLs))
+	for _, page := range cw.Pages {
+		paths = append(paths, "/page/"+url.PathEscape(page)+".html")
+	}
+	return append(paths, cw.URLs...)
+}
+
+// WarmCaches requests each of cw's pages and URLs once, in order, through
+// ws.Handler().  Proxied URLs are requested with Cache-Control: no-cache, so
+// their cached responses are refreshed from the upstream, both with and
+// without gzip, filling both variants a ProxyCache keeps.
+func (ws *WebService) WarmCaches(ctx context.Context, cw CacheWarming) WarmReport {
+	report := WarmReport{Time: time.Now(), Failures: []string{}}
+	handler := ws.Handler()
+	pages := len(cw.Pages)
+	for i, path := range cw.targets() {
+		if ctx.Err() != nil {
+			break
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			report.Failures = append(report.Failures, path+": "+err.Error())
+			continue
+		}
+		r.RemoteAddr = "127.0.0.1:0"
+		for k, v := range cw.Header {
+			r.Header[k] = append([]string(nil), v...)
+		}
+		r.Header.Set("User-Agent", "fibre-warmer")
+		if i >= pages {
+			r.Header.Set("Cache-Control", "no-cache")
+		}
+
+		status := serveWarm(handler, r)
+		if i >= pages && status < 400 {
+			gz := r.Clone(ctx)
+			gz.Header.Set("Accept-Encoding", "gzip")
+			status = serveWarm(handler, gz)
+		}
+		if status >= 400 {
+			ws.Metrics.Inc("cache_warm_failures")
+			report.Failures = append(report.Failures, path+": "+http.StatusText(status))
+			continue
+		}
+		ws.Metrics.Inc("cache_warmed")
+		report.Warmed++
+	}
+	report.Duration = time.Since(report.Time)
+	return report
+}
+
+// serveWarm serves r through handler, returning the response status.
+func serveWarm(handler http.Handler, r *http.Request) int {
+	lw := &loadWriter{header: make(http.Header)}
+	handler.ServeHTTP(lw, r)
+	if lw.status == 0 {
+		return http.StatusOK
+	}
+	return lw.status
+}
+
+// EnableCacheWarming warms cw's pages and URLs as soon as the instance
+// starts, and again every cw.Interval (DefaultWarmInterval when zero) until
+// it shuts down, logging failures.
+func (ws *WebService) EnableCacheWarming(cw CacheWarming) {
+	interval := orDefault(cw.Interval, DefaultWarmInterval)
+	ws.warming = &cw
+
+	var (
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	)
+	ws.OnStartup(Hook{Name: "cache warming", Run: func(context.Context) error {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				report := ws.WarmCaches(ctx, cw)
+				if len(report.Failures) > 0 && ws.logs(LogWarn) {
+					log.Printf("%v cache warming: %d warmed, %d failed: %v", ws.Instance, report.Warmed, len(report.Failures), report.Failures)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		return nil
+	}})
+	ws.OnShutdown(Hook{Name: "cache warming", Run: func(ctx context.Context) error {
+		if cancel == nil {
+			return nil
+		}
+		cancel()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}})
+}
@@ -0,0 +1,78 @@
+package fibre
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmCaches(t *testing.T) {
+	var calls atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Api_key") != "" {
+			t.Errorf("WarmCaches sent its api_key upstream")
+		}
+		w.Write([]byte("catalog"))
+	}))
+	defer backend.Close()
+
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.PageCache = NewPageCache()
+	cache := &ProxyCache{TTL: time.Hour}
+	ws.Proxy([]ProxyConfig{{Path: "/catalog", Host: backend.URL, Cache: cache, StripHeaders: []string{"Api_key"}}})
+
+	cw := CacheWarming{Pages: []string{"index", "missing"}, URLs: []string{"/catalog"}, Header: http.Header{"Api_key": {"k"}}}
+	report := ws.WarmCaches(context.Background(), cw)
+	if report.Warmed != 2 || len(report.Failures) != 1 {
+		t.Errorf("WarmCaches returned unexpected report: %+v", report)
+	}
+	if _, _, ok := ws.PageCache.Get(PageKey{Page: "index"}); !ok {
+		t.Errorf("WarmCaches did not render the index page")
+	}
+
+	// a second pass refreshes both variants of the proxied URL from its
+	// upstream.
+	ws.WarmCaches(context.Background(), cw)
+	if n := calls.Load(); n != 4 {
+		t.Errorf("WarmCaches made %d upstream calls, want 4", n)
+	}
+	for _, encoding := range []string{"", "gzip, deflate, br"} {
+		req := httptest.NewRequest("GET", "/catalog", nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		ws.Handler().ServeHTTP(httptest.NewRecorder(), req)
+		if n := calls.Load(); n != 4 {
+			t.Errorf("proxied URL was not served from the warmed cache for Accept-Encoding %q: %d upstream calls", encoding, n)
+		}
+	}
+}
+
+func TestEnableCacheWarming(t *testing.T) {
+	ws := NewWebService("test", "127.0.0.1:7999")
+	ws.PageCache = NewPageCache()
+	ws.EnableCacheWarming(CacheWarming{Pages: []string{"index"}, Interval: 10 * time.Millisecond})
+
+	if err := ws.Start(context.Background()); err != nil {
+		t.Fatalf("Start returned unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for ws.Metrics.Get("cache_warmed") < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := ws.stop(context.Background()); err != nil {
+		t.Fatalf("stop returned unexpected error: %v", err)
+	}
+	warmed := ws.Metrics.Get("cache_warmed")
+	if warmed < 2 {
+		t.Errorf("cache warming did not repeat: %d pages warmed", warmed)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := ws.Metrics.Get("cache_warmed"); n != warmed {
+		t.Errorf("cache warming continued after shutdown: %d pages warmed, then %d", warmed, n)
+	}
+}